@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Semior001/remapjson/pkg/config"
+)
+
+// Seal command seals the webhook configuration into a token offline.
+type Seal struct {
+	URL         string `long:"url"          description:"remote URL to forward requests to, if empty, the webhook responds with the rendered output itself"`
+	Template    string `long:"template"     description:"Go template to remap the incoming JSON with"`
+	Secret      string `long:"secret"       env:"SECRET"       description:"secret for sealing webhook configurations" required:"true"` //nolint:gosec // intentional secret field
+	BaseURL     string `long:"base-url"     env:"BASE_URL"     description:"base URL of the server, if set, the webhook URL is printed instead of the token"`
+	RoutePrefix string `long:"route-prefix" env:"ROUTE_PREFIX" description:"path prefix of all routes of the server, e.g. /remapjson, for the printed webhook URL"`
+
+	Unencrypted bool `long:"unencrypted" description:"only sign the token without encrypting it, for public webhooks without sensitive data"`
+	Bind        bool `long:"bind"        description:"bind the token to the host of the base URL, for servers running with --bind-tokens"`
+
+	CommonOpts
+}
+
+// Execute prints the token for the configuration.
+func (c Seal) Execute([]string) error {
+	cfg := config.Webhook{URL: c.URL, Tmpl: c.Template}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	sealer := config.Sealer{Secret: c.Secret, Unencrypted: c.Unencrypted}
+	if c.Bind {
+		var err error
+		if sealer.Context, err = tokenContext(c.BaseURL); err != nil {
+			return fmt.Errorf("bind token: %w", err)
+		}
+	}
+
+	token, err := sealer.Seal(cfg)
+	if err != nil {
+		return fmt.Errorf("seal configuration: %w", err)
+	}
+
+	if c.BaseURL != "" {
+		fmt.Printf("%s%s/wh/%s\n", strings.TrimSuffix(c.BaseURL, "/"), routePrefix(c.RoutePrefix), token)
+		return nil
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+// Unseal command prints the webhook configuration sealed into a token.
+type Unseal struct {
+	Token       string   `long:"token"          description:"token or the whole webhook URL" required:"true"`
+	Secret      string   `long:"secret"         env:"SECRET"          description:"secret for sealing webhook configurations" required:"true"` //nolint:gosec // intentional secret field
+	Retired     []string `long:"retired-secret" env:"RETIRED_SECRETS" env-delim:"," description:"previous secret, tokens sealed with it are still accepted, can be repeated"`
+	BaseURL     string   `long:"base-url"       env:"BASE_URL"        description:"base URL of the server, required to unseal the tokens bound to its host"`
+	RoutePrefix string   `long:"route-prefix"   env:"ROUTE_PREFIX"    description:"path prefix of all routes of the server, e.g. /remapjson, stripped from the webhook URL"`
+
+	CommonOpts
+}
+
+// Execute prints the configuration as JSON.
+func (c Unseal) Execute([]string) error {
+	token := c.Token
+	if idx := strings.Index(token, routePrefix(c.RoutePrefix)+"/wh/"); idx != -1 {
+		token = token[idx+len(routePrefix(c.RoutePrefix)+"/wh/"):]
+	}
+	token, _, _ = strings.Cut(token, "/") // the path after the token, if any
+
+	sealer := config.Sealer{Secret: c.Secret, Retired: c.Retired, AcceptUnbound: true}
+	if c.BaseURL != "" {
+		var err error
+		if sealer.Context, err = tokenContext(c.BaseURL); err != nil {
+			return fmt.Errorf("bind token: %w", err)
+		}
+	}
+
+	cfg, err := sealer.Unseal(token)
+	if err != nil {
+		return fmt.Errorf("unseal token: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(cfg); err != nil {
+		return fmt.Errorf("print configuration: %w", err)
+	}
+
+	return nil
+}
+
+// routePrefix returns the route prefix with a leading and without a trailing
+// slash, or empty if the routes are served at the root, same as the server.
+func routePrefix(prefix string) string {
+	if p := strings.Trim(prefix, "/"); p != "" {
+		return "/" + p
+	}
+	return ""
+}
+
+// tokenContext returns the context to bind the tokens to, the host
+// of the server base URL.
+func tokenContext(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parse base URL: %w", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("base URL %q has no host", baseURL)
+	}
+	return strings.ToLower(u.Host), nil
+}
@@ -1,25 +1,110 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/Semior001/remapjson/pkg/config"
 	"github.com/Semior001/remapjson/pkg/rest"
+	"github.com/Semior001/remapjson/pkg/store"
 	slogxl "github.com/cappuccinotm/slogx/logger"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // Server command starts the HTTP server.
 type Server struct {
-	Addr     string        `long:"addr"     env:"ADDR"     description:"address to listen on" default:":8080"`
+	Addr     string        `long:"addr"     env:"ADDR"     description:"address to listen on, unix:/path/to.sock for a Unix socket" default:":8080"`
 	Timeout  time.Duration `long:"timeout"  env:"TIMEOUT"  description:"HTTP client timeout"  default:"90s"`
 	BaseURL  string        `long:"base-url" env:"BASE_URL" description:"base URL for webhook" required:"true"`
 	Secret   string        `long:"secret"   env:"SECRET"   description:"secret for sealing webhook configurations" required:"true"` //nolint:gosec // intentional secret field
+	Retired  []string      `long:"retired-secret" env:"RETIRED_SECRETS" env-delim:"," description:"previous secret, tokens sealed with it are still accepted, can be repeated"` //nolint:gosec // intentional secret field
 	Password string        `long:"password" env:"PASSWORD" description:"password for basic auth, if not set, basic auth is disabled"`   //nolint:gosec // intentional secret field
 
+	APIKey string `long:"api-key" env:"API_KEY" description:"API key accepted in X-API-Key header by the web API instead of basic auth, if not set, API keys are disabled"` //nolint:gosec // intentional secret field
+
+	Unencrypted bool `long:"unencrypted-tokens" env:"UNENCRYPTED_TOKENS" description:"only sign new tokens without encrypting them, for public webhooks without sensitive data"`
+
+	BindTokens          bool `long:"bind-tokens"           env:"BIND_TOKENS"           description:"bind new tokens to the host of the base URL, so that they are rejected by servers with other base URLs"`
+	AcceptUnboundTokens bool `long:"accept-unbound-tokens" env:"ACCEPT_UNBOUND_TOKENS" description:"with --bind-tokens, still accept the tokens sealed without binding"`
+
+	MaxBodySize int64   `long:"max-body-size" env:"MAX_BODY_SIZE" description:"maximum request body size in bytes" default:"1048576"`
+	RateLimit   float64 `long:"rate-limit"    env:"RATE_LIMIT"    description:"maximum requests per second per client, 0 disables the limit" default:"10"`
+	Store       string  `long:"store"         env:"STORE"         description:"path to the file to keep track of configured webhooks, enables /admin/export and /admin/import"`
+
+	MaxIdleConnsPerHost int           `long:"max-idle-conns-per-host" env:"MAX_IDLE_CONNS_PER_HOST" description:"maximum idle keep-alive connections to keep per remote host" default:"32"`
+	MaxConnsPerHost     int           `long:"max-conns-per-host"      env:"MAX_CONNS_PER_HOST"      description:"maximum connections per remote host, 0 means no limit" default:"0"`
+	IdleConnTimeout     time.Duration `long:"idle-conn-timeout"       env:"IDLE_CONN_TIMEOUT"       description:"how long an idle keep-alive connection to a remote is kept open" default:"90s"`
+
+	InsecureSkipVerify bool   `long:"insecure-skip-verify" env:"INSECURE_SKIP_VERIFY" description:"do not verify TLS certificates of remotes, INSECURE: exposes deliveries to man-in-the-middle attacks, use only for testing"`
+	RemoteCAFile       string `long:"remote-ca-file"       env:"REMOTE_CA_FILE"       description:"path to PEM file with CA certificates to trust for remotes, in addition to the system ones"`
+
+	AccessLog        string `long:"access-log"          env:"ACCESS_LOG"          description:"path to the file to write the JSON lines access log of webhook deliveries to"`
+	AccessLogMaxSize int    `long:"access-log-max-size" env:"ACCESS_LOG_MAX_SIZE" description:"maximum size of the access log file in megabytes before it gets rotated" default:"100"`
+
+	MaxTimeout      time.Duration `long:"max-timeout"      env:"MAX_TIMEOUT"      description:"maximum outbound request timeout, caps --timeout and the ones configured per webhook, 0 means no cap" default:"5m"`
+	TemplateTimeout time.Duration `long:"template-timeout" env:"TEMPLATE_TIMEOUT" description:"maximum template execution time, 0 means no limit (advisory, see README)" default:"5s"`
+
+	TemplateCacheSize int `long:"template-cache-size" env:"TEMPLATE_CACHE_SIZE" description:"maximum number of parsed templates, unsealed configurations and schemas kept in memory each, least recently used are evicted first, 0 means no limit" default:"10000"`
+
+	MaxResponseSize int64 `long:"max-response-size" env:"MAX_RESPONSE_SIZE" description:"maximum remote response body size in bytes, 0 means no limit" default:"0"`
+
+	BreakerThreshold int           `long:"breaker-threshold" env:"BREAKER_THRESHOLD" description:"consecutive failures of a remote host to stop sending requests to it for the cooldown, 0 disables circuit breaking" default:"0"`
+	BreakerCooldown  time.Duration `long:"breaker-cooldown"  env:"BREAKER_COOLDOWN"  description:"how long requests to a failing remote host are rejected" default:"30s"`
+
+	RedirectPolicy string `long:"redirect-policy" env:"REDIRECT_POLICY" choice:"follow" choice:"no-follow" choice:"same-host" description:"how to handle redirects of remotes: follow them, return them to the caller as is, or follow within the same host only" default:"follow"`
+
+	UserAgent string `long:"user-agent" env:"USER_AGENT" description:"User-Agent of outbound requests (default: remapjson/<version>)"`
+
+	RequestIDHeader string `long:"request-id-header" env:"REQUEST_ID_HEADER" description:"header of outbound requests carrying the ID of the incoming request, - disables it" default:"X-Request-ID"`
+
+	TemplateEnvAllow []string `long:"template-env-allow" env:"TEMPLATE_ENV_ALLOW" env-delim:"," description:"environment variable readable in templates with the env function, can be repeated"`
+
+	TrustedProxies []string `long:"trusted-proxies" env:"TRUSTED_PROXIES" env-delim:"," description:"CIDR or IP of a proxy trusted to pass the client IP in X-Forwarded-For and X-Real-IP, can be repeated, if not set, the headers are trusted from any peer"`
+
+	AllowedSchemes []string `long:"allowed-scheme" env:"ALLOWED_SCHEMES" env-delim:"," description:"URL scheme of remotes allowed to be configured, can be repeated" default:"http" default:"https"`
+
+	CORSOrigins []string `long:"cors-origin" env:"CORS_ORIGINS" env-delim:"," description:"origin allowed to call the web API from a browser, * allows any, can be repeated"`
+	CORSMethods []string `long:"cors-method" env:"CORS_METHODS" env-delim:"," description:"method allowed in cross-origin requests, can be repeated (default: GET, POST)"`
+	CORSHeaders []string `long:"cors-header" env:"CORS_HEADERS" env-delim:"," description:"header allowed in cross-origin requests, can be repeated (default: headers used by the web UI)"`
+
+	LogBodies    bool     `long:"log-bodies"   env:"LOG_BODIES"    description:"log inbound and outbound webhook bodies, works only with --debug"`
+	RedactFields []string `long:"redact-field" env:"REDACT_FIELDS" env-delim:"," description:"name of the field masked in logged bodies, can be repeated" default:"password" default:"passwd" default:"secret" default:"token" default:"api_key" default:"apikey" default:"authorization"`
+
+	NoRemoteLog bool `long:"no-remote-log" env:"NO_REMOTE_LOG" description:"do not log the host, status and latency of remote responses"`
+
+	NoWebUI     bool `long:"no-web-ui"     env:"NO_WEB_UI"     description:"disable the web UI and its API, leaving only webhooks and health check"`
+	AllowDryRun bool `long:"allow-dry-run" env:"ALLOW_DRY_RUN" description:"allow X-RemapJSON-DryRun header on webhooks to render the outbound request without sending it"`
+	LenientJSON bool `long:"lenient-json"  env:"LENIENT_JSON"  description:"tolerate comments and trailing commas in JSON payloads of webhooks"`
+
+	MaxJSONDepth int `long:"max-json-depth" env:"MAX_JSON_DEPTH" description:"maximum nesting depth of JSON and YAML payloads of webhooks" default:"64"`
+
+	IdempotencyTTL  time.Duration `long:"idempotency-ttl"  env:"IDEMPOTENCY_TTL"  description:"how long to remember remote responses for idempotent retries, 0 disables replays" default:"24h"`
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" env:"SHUTDOWN_TIMEOUT" description:"how long to wait for in-flight requests on shutdown" default:"10s"`
+
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" env:"READ_HEADER_TIMEOUT" description:"how long to wait for the headers of incoming requests" default:"5s"`
+	WriteTimeout      time.Duration `long:"write-timeout"       env:"WRITE_TIMEOUT"       description:"maximum time to handle a request and write the response, should exceed --timeout" default:"2m"`
+	IdleTimeout       time.Duration `long:"idle-timeout"        env:"IDLE_TIMEOUT"        description:"how long an idle keep-alive connection of a client is kept open" default:"30s"`
+
+	ShutdownDelay time.Duration `long:"shutdown-delay" env:"SHUTDOWN_DELAY" description:"how long to keep serving on shutdown with /readyz failing, for load balancers to drain traffic" default:"0s"`
+
+	RoutePrefix string `long:"route-prefix" env:"ROUTE_PREFIX" description:"path prefix of all routes, e.g. /remapjson behind a path-based reverse proxy"`
+
+	WebDir string `long:"web-dir" env:"WEB_DIR" description:"directory with web UI files overriding the embedded ones, e.g. theme.css"`
+
+	CoalesceWindow time.Duration `long:"coalesce-window" env:"COALESCE_WINDOW" description:"share a single delivery between identical concurrent requests to a webhook, and the ones within the window after it, 0 disables coalescing" default:"0s"`
+
+	DeliveryTTL time.Duration `long:"delivery-ttl" env:"DELIVERY_TTL" description:"how long to keep delivery receipts, queried at /deliveries/{id}, 0 disables receipts" default:"0s"`
+
+	ReplayBodySize      int64 `long:"replay-body-size"      env:"REPLAY_BODY_SIZE"      description:"maximum size of the incoming body kept to replay the delivery at /deliveries/{id}/replay, requires --delivery-ttl and --password or --api-key, 0 disables replays" default:"0"`
+	EncryptReplayBodies bool  `long:"encrypt-replay-bodies" env:"ENCRYPT_REPLAY_BODIES" description:"encrypt the requests kept to replay deliveries in memory"`
+
 	CommonOpts
 }
 
@@ -29,23 +114,190 @@ func (c Server) Execute([]string) error {
 
 	debug := slog.Default().Enabled(ctx, slog.LevelDebug)
 
+	transport, err := c.transport()
+	if err != nil {
+		return fmt.Errorf("make http transport: %w", err)
+	}
+
+	if c.ReplayBodySize > 0 && (c.DeliveryTTL <= 0 || (c.Password == "" && c.APIKey == "")) {
+		slog.Warn("delivery replays are disabled, as they require --delivery-ttl and --password or --api-key")
+	}
+
+	if timeout := c.outboundTimeout(); timeout > 0 && c.WriteTimeout < timeout+writeTimeoutMargin {
+		slog.Warn("write timeout doesn't leave room for the outbound timeout, responses of slow remotes may be truncated",
+			slog.Duration("write_timeout", c.WriteTimeout), slog.Duration("outbound_timeout", timeout))
+	}
+
+	trustedProxies, err := parsePrefixes(c.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("parse trusted proxies: %w", err)
+	}
+
+	sealer := config.Sealer{Secret: c.Secret, Retired: c.Retired, Unencrypted: c.Unencrypted}
+	if c.BindTokens {
+		if sealer.Context, err = tokenContext(c.BaseURL); err != nil {
+			return fmt.Errorf("bind tokens: %w", err)
+		}
+		sealer.AcceptUnbound = c.AcceptUnboundTokens
+	}
+
 	srv := rest.Server{
 		Addr:     c.Addr,
 		BaseURL:  strings.TrimSuffix(c.BaseURL, "/"),
-		Version:  c.ApplicationVersion,
-		Password: c.Password,
-		Sealer:   config.Sealer{Secret: c.Secret},
-		Client:   &http.Client{Timeout: c.Timeout},
+		Version:   c.ApplicationVersion,
+		BuildDate: c.ApplicationBuildDate,
+		Password:  c.Password,
+		APIKey:    c.APIKey,
+		Sealer:    sealer,
+		Client:   &http.Client{Timeout: c.Timeout, Transport: transport, CheckRedirect: c.checkRedirect()},
 		Debug:    debug,
+
+		MaxBodySize: c.MaxBodySize,
+		RateLimit:   c.RateLimit,
+
+		IdempotencyTTL:  c.IdempotencyTTL,
+		CoalesceWindow:  c.CoalesceWindow,
+		ShutdownTimeout: c.ShutdownTimeout,
+		ShutdownDelay:   c.ShutdownDelay,
+
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+
+		AllowDryRun:     c.AllowDryRun,
+		LenientJSON:     c.LenientJSON,
+		MaxJSONDepth:    c.MaxJSONDepth,
+		NoWebUI:         c.NoWebUI,
+		LogBodies:       c.LogBodies,
+		NoRemoteLog:     c.NoRemoteLog,
+		RedactFields:    c.RedactFields,
+		CORS:            rest.CORS{Origins: c.CORSOrigins, Methods: c.CORSMethods, Headers: c.CORSHeaders},
+		AllowedSchemes:  c.AllowedSchemes,
+		MaxTimeout:      c.MaxTimeout,
+		TemplateTimeout: c.TemplateTimeout,
+		TemplateEnv:     c.TemplateEnvAllow,
+		UserAgent:       c.UserAgent,
+		RequestIDHeader: c.RequestIDHeader,
+		MaxResponseSize: c.MaxResponseSize,
+
+		BreakerThreshold: c.BreakerThreshold,
+		BreakerCooldown:  c.BreakerCooldown,
+
+		DeliveryTTL: c.DeliveryTTL,
+		WebDir:      c.WebDir,
+		RoutePrefix: c.RoutePrefix,
+
+		TemplateCacheSize: c.TemplateCacheSize,
+
+		ReplayBodySize:      c.ReplayBodySize,
+		EncryptReplayBodies: c.EncryptReplayBodies,
+
+		TrustedProxies: trustedProxies,
+	}
+
+	if c.Store != "" {
+		st, serr := store.NewFile(c.Store)
+		if serr != nil {
+			return fmt.Errorf("open store: %w", serr)
+		}
+		srv.Store = st
+	}
+
+	if c.AccessLog != "" {
+		al := &lumberjack.Logger{Filename: c.AccessLog, MaxSize: c.AccessLogMaxSize}
+		defer al.Close()
+		srv.AccessLog = al
 	}
 
 	if debug {
-		srv.Client.Transport = slogxl.New().HTTPClientRoundTripper(http.DefaultTransport)
+		srv.Client.Transport = slogxl.New().HTTPClientRoundTripper(transport)
 	}
 
-	if err := srv.Run(ctx); err != nil {
+	if err = srv.Run(ctx); err != nil {
 		return fmt.Errorf("run server: %w", err)
 	}
 
 	return nil
 }
+
+// writeTimeoutMargin is the time the write timeout should leave, on top of
+// the outbound timeout, to render the request and write the response.
+const writeTimeoutMargin = 10 * time.Second
+
+// outboundTimeout returns the timeout of the outbound requests of webhooks
+// without their own timeout, 0 means no timeout.
+func (c Server) outboundTimeout() time.Duration {
+	if c.MaxTimeout > 0 && (c.Timeout == 0 || c.Timeout > c.MaxTimeout) {
+		return c.MaxTimeout
+	}
+	return c.Timeout
+}
+
+// checkRedirect returns the redirect policy of the outbound requests.
+func (c Server) checkRedirect() func(req *http.Request, via []*http.Request) error {
+	switch c.RedirectPolicy {
+	case "no-follow":
+		return func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	case "same-host":
+		return rest.SameHostRedirects
+	default:
+		return nil // the client follows up to 10 redirects
+	}
+}
+
+// parsePrefixes parses the networks in CIDR notation, a bare IP address
+// is parsed as a network of the single address.
+func parsePrefixes(vals []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(vals))
+	for _, val := range vals {
+		if addr, err := netip.ParseAddr(val); err == nil {
+			res = append(res, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(val)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, prefix.Masked())
+	}
+	return res, nil
+}
+
+// transport makes the transport for outbound requests, tuned for keeping
+// connections to busy remotes alive, and trusting the extra CA certificates,
+// if provided.
+func (c Server) transport() (*http.Transport, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConns = 0 // limited per host
+	tr.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	tr.MaxConnsPerHost = c.MaxConnsPerHost
+	tr.IdleConnTimeout = c.IdleConnTimeout
+	tr.ForceAttemptHTTP2 = true // custom TLS config disables HTTP/2 otherwise
+
+	if c.RemoteCAFile != "" {
+		pem, err := os.ReadFile(c.RemoteCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read remote CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("load system cert pool: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.RemoteCAFile)
+		}
+
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if c.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification of remotes is disabled")
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		tr.TLSClientConfig.InsecureSkipVerify = true //nolint:gosec // explicitly requested by the operator
+	}
+
+	return tr, nil
+}
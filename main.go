@@ -24,6 +24,8 @@ import (
 var opts struct {
 	Version cmd.Version `command:"version" description:"print application version and build date"`
 	Server  cmd.Server  `command:"server" description:"run the server"`
+	Seal    cmd.Seal    `command:"seal" description:"seal webhook configuration into a token"`
+	Unseal  cmd.Unseal  `command:"unseal" description:"print webhook configuration sealed into a token"`
 
 	JSON  bool `long:"json"  env:"JSON"  description:"Enable JSON logging"`
 	Debug bool `long:"debug" env:"DEBUG" description:"Enable debug mode"`
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AuthType selects how the outbound request is authenticated.
+type AuthType string
+
+// Supported authentication types.
+const (
+	AuthTypeBearer AuthType = "bearer" // Authorization: Bearer <token>
+	AuthTypeBasic  AuthType = "basic"  // Authorization: Basic base64(<user>:<pass>)
+)
+
+// Auth describes the credentials to authenticate the outbound request with.
+// Token, User and Pass are templates, executed with the incoming payload.
+type Auth struct {
+	Type  AuthType `json:"type"`
+	Token string   `json:"token,omitempty"` //nolint:gosec // intentional secret field
+	User  string   `json:"user,omitempty"`
+	Pass  string   `json:"pass,omitempty"` //nolint:gosec // intentional secret field
+}
+
+// Validate checks that the credentials required by the type are set.
+func (a Auth) Validate() error {
+	switch a.Type {
+	case AuthTypeBearer:
+		if a.Token == "" {
+			return errors.New("bearer token is required")
+		}
+	case AuthTypeBasic:
+		if a.User == "" {
+			return errors.New("basic auth user is required")
+		}
+	default:
+		return fmt.Errorf("unknown auth type %q", a.Type)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    Auth
+		wantErr string
+	}{
+		{name: "bearer", auth: Auth{Type: AuthTypeBearer, Token: "{{.token}}"}},
+		{name: "basic", auth: Auth{Type: AuthTypeBasic, User: "user", Pass: "pass"}},
+		{name: "basic without password", auth: Auth{Type: AuthTypeBasic, User: "user"}},
+		{name: "bearer without token", auth: Auth{Type: AuthTypeBearer}, wantErr: "bearer token is required"},
+		{name: "basic without user", auth: Auth{Type: AuthTypeBasic, Pass: "pass"}, wantErr: "basic auth user is required"},
+		{name: "unknown type", auth: Auth{Type: "digest"}, wantErr: `unknown auth type "digest"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.auth.Validate()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
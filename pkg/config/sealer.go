@@ -1,40 +1,87 @@
 package config
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 )
 
+// plaintext format flags, prepended to the sealed configuration, they
+// version the format of the plaintext, so that it can be changed without
+// invalidating the issued tokens: decode dispatches on the flag, and a new
+// format gets a new flag. Tokens sealed before the flags were introduced
+// start with '{', which is never used as a flag.
+const (
+	flagPlain byte = 0x00 // raw JSON
+	flagGzip  byte = 0x01 // gzip-compressed JSON
+)
+
+// flagSigned starts the tokens, which are signed, but not encrypted.
+// Encrypted tokens start with the random nonce, which may coincide with the
+// flag, so such tokens are unsealed as encrypted if the signature doesn't match.
+const flagSigned byte = 0x53
+
+// signatureSize is the size of the truncated HMAC-SHA256 of signed tokens.
+const signatureSize = 16
+
 // Sealer provides methods to seal and unseal webhook configurations.
+// Configurations are always sealed with Secret, while tokens sealed with
+// any of the Retired secrets are still accepted, which allows to rotate
+// the secret without invalidating the issued webhook URLs at once.
 type Sealer struct {
-	Secret string //nolint:gosec // intentional secret field
+	Secret  string   //nolint:gosec // intentional secret field
+	Retired []string // previous secrets, still trusted to unseal tokens
+
+	// Unencrypted makes Seal to only sign the configurations, so that
+	// anyone with the token can read, but not alter them. Such tokens are
+	// shorter and suit public webhooks without sensitive data. The ones
+	// carrying secrets, e.g. credentials, are encrypted nevertheless.
+	// Unseal accepts both signed and encrypted tokens regardless.
+	Unencrypted bool
+
+	// Context, if set, binds the tokens to it, e.g. to the host of the
+	// server, as the additional authenticated data, so that tokens sealed
+	// in one context can't be unsealed in another. Tokens sealed without
+	// a context are accepted only if AcceptUnbound is set, to keep the
+	// tokens issued before the binding was enabled working.
+	Context       string
+	AcceptUnbound bool
 }
 
-type sealedConfig struct {
-	URL  string `json:"url"`
-	Tmpl string `json:"tmpl"`
+// Fingerprint returns a short, non-reversible identifier of the token,
+// safe to be logged or stored alongside it.
+func Fingerprint(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:8])
 }
 
-// Seal takes a URL and a template string, encrypts them, and returns a token that can be used to retrieve the original values later.
-func (s Sealer) Seal(urlStr, tmplStr string) (string, error) {
-	key := sha256.Sum256([]byte(s.Secret))
-	block, err := aes.NewCipher(key[:])
+// Seal takes a webhook configuration, encrypts it, and returns a token that can be used to retrieve the original values later.
+func (s Sealer) Seal(cfg Webhook) (string, error) {
+	plaintext, err := encode(cfg)
 	if err != nil {
-		return "", fmt.Errorf("create cipher: %w", err)
+		return "", err
 	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", fmt.Errorf("create GCM: %w", err)
+
+	if s.Unencrypted && !hasSecrets(cfg) {
+		signed := append([]byte{flagSigned}, plaintext...)
+		signed = append(signed, sign(s.Secret, s.Context, plaintext)...)
+		return base64.URLEncoding.EncodeToString(signed), nil
 	}
 
-	plaintext, err := json.Marshal(sealedConfig{URL: urlStr, Tmpl: tmplStr})
+	gcm, err := newGCM(s.Secret)
 	if err != nil {
-		return "", fmt.Errorf("marshal config: %w", err)
+		return "", err
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
@@ -42,42 +89,176 @@ func (s Sealer) Seal(urlStr, tmplStr string) (string, error) {
 		return "", fmt.Errorf("generate nonce: %w", err)
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, []byte(s.Context))
 	return base64.URLEncoding.EncodeToString(ciphertext), nil
 }
 
-// Unseal decodes the token and returns the original URL and template strings.
-func (s Sealer) Unseal(token string) (urlStr, tmplStr string, err error) {
+// Unseal decodes the token and returns the original webhook configuration.
+func (s Sealer) Unseal(token string) (cfg Webhook, err error) {
 	data, err := base64.URLEncoding.DecodeString(token)
 	if err != nil {
-		return "", "", fmt.Errorf("decode token: %w", err)
+		return Webhook{}, fmt.Errorf("decode token: %w", err)
 	}
 
-	key := sha256.Sum256([]byte(s.Secret))
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return "", "", fmt.Errorf("create cipher: %w", err)
+	secrets := append([]string{s.Secret}, s.Retired...)
+	contexts := []string{s.Context}
+	if s.Context != "" && s.AcceptUnbound {
+		contexts = append(contexts, "")
 	}
-	gcm, err := cipher.NewGCM(block)
+
+	if len(data) > signatureSize && data[0] == flagSigned {
+		plaintext, sig := data[1:len(data)-signatureSize], data[len(data)-signatureSize:]
+		for _, secret := range secrets {
+			for _, ctx := range contexts {
+				if hmac.Equal(sign(secret, ctx, plaintext), sig) {
+					return decode(plaintext)
+				}
+			}
+		}
+		// might be an encrypted token, which nonce starts with the flag
+	}
+
+	var plaintext []byte
+	for _, secret := range secrets {
+		for _, ctx := range contexts {
+			if plaintext, err = open(secret, ctx, data); err == nil {
+				return decode(plaintext)
+			}
+		}
+	}
+
+	return Webhook{}, err
+}
+
+// UnsealCurrent is Unseal accepting only the tokens, which will still be
+// accepted after the rotation: sealed with the current secret, and bound
+// to the context, if set. The others have to be resealed.
+func (s Sealer) UnsealCurrent(token string) (Webhook, error) {
+	s.Retired, s.AcceptUnbound = nil, false
+	return s.Unseal(token)
+}
+
+// hasSecrets reports whether the configuration carries secrets, which
+// must not be readable by the holders of the token: the credentials of
+// the outbound request, including the ones in the static headers, query
+// parameters and the userinfo of the URL, and the inbound and outbound
+// signing secrets.
+func hasSecrets(cfg Webhook) bool {
+	if cfg.Auth != nil || cfg.OutboundSigning != nil || (cfg.Signature != nil && cfg.Signature.Secret != "") {
+		return true
+	}
+
+	for _, values := range []map[string]string{cfg.Headers, cfg.Query} {
+		for _, v := range values {
+			if v != "" {
+				return true
+			}
+		}
+	}
+
+	u, err := url.Parse(cfg.URL)
+	return err != nil || u.User != nil // can't tell, if the URL is malformed
+}
+
+// sign returns the truncated HMAC-SHA256 of the plaintext with the key
+// derived from the secret and the context, distinct from the encryption one.
+func sign(secret, context string, plaintext []byte) []byte {
+	material := "sign:" + secret
+	if context != "" { // keep the keys of the unbound tokens as they were
+		material += "\x00" + context
+	}
+	key := sha256.Sum256([]byte(material))
+	mac := hmac.New(sha256.New, key[:])
+	_, _ = mac.Write(plaintext)
+	return mac.Sum(nil)[:signatureSize]
+}
+
+// open decrypts the sealed data with the given secret and context.
+func open(secret, context string, data []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
 	if err != nil {
-		return "", "", fmt.Errorf("create GCM: %w", err)
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
 	if len(data) < nonceSize {
-		return "", "", fmt.Errorf("token too short")
+		return nil, errors.New("token too short")
 	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt token: %w", err)
+	}
+	return plaintext, nil
+}
+
+// newGCM makes AES-256-GCM cipher with the key derived from the secret.
+func newGCM(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", "", fmt.Errorf("decrypt token: %w", err)
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// encode marshals the configuration and compresses it, if that makes
+// the plaintext shorter, prepending the format flag.
+func encode(cfg Webhook) ([]byte, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+
+	buf := bytes.NewBuffer([]byte{flagGzip})
+	gz, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("create gzip writer: %w", err)
+	}
+	if _, err = gz.Write(raw); err != nil {
+		return nil, fmt.Errorf("compress config: %w", err)
+	}
+	if err = gz.Close(); err != nil {
+		return nil, fmt.Errorf("compress config: %w", err)
+	}
+
+	if buf.Len() < len(raw)+1 {
+		return buf.Bytes(), nil
+	}
+	return append([]byte{flagPlain}, raw...), nil
+}
+
+// decode dispatches on the format flag and unmarshals the configuration.
+func decode(plaintext []byte) (cfg Webhook, err error) {
+	if len(plaintext) == 0 {
+		return Webhook{}, fmt.Errorf("empty config")
+	}
+
+	raw := plaintext
+	switch plaintext[0] {
+	case '{': // legacy token without format flag
+	case flagPlain:
+		raw = plaintext[1:]
+	case flagGzip:
+		gz, gerr := gzip.NewReader(bytes.NewReader(plaintext[1:]))
+		if gerr != nil {
+			return Webhook{}, fmt.Errorf("decompress config: %w", gerr)
+		}
+		if raw, err = io.ReadAll(gz); err != nil {
+			return Webhook{}, fmt.Errorf("decompress config: %w", err)
+		}
+	default:
+		return Webhook{}, fmt.Errorf("unknown config format %#x, the token may be sealed by a newer version", plaintext[0])
 	}
 
-	var cfg sealedConfig
-	if err = json.Unmarshal(plaintext, &cfg); err != nil {
-		return "", "", fmt.Errorf("unmarshal config: %w", err)
+	if err = json.Unmarshal(raw, &cfg); err != nil {
+		return Webhook{}, fmt.Errorf("unmarshal config: %w", err)
 	}
 
-	return cfg.URL, cfg.Tmpl, nil
+	return cfg, nil
 }
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // some providers still sign with HMAC-SHA1
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureProvider selects a preset scheme to verify signatures
+// of incoming requests.
+type SignatureProvider string
+
+// Supported signature providers.
+const (
+	SignatureProviderNone    SignatureProvider = ""
+	SignatureProviderCustom  SignatureProvider = "custom"  // HMAC over the body, as configured in Signature
+	SignatureProviderGitHub  SignatureProvider = "github"  // X-Hub-Signature-256: sha256=<hex>
+	SignatureProviderShopify SignatureProvider = "shopify" // X-Shopify-Hmac-Sha256: <base64>
+	SignatureProviderStripe  SignatureProvider = "stripe"  // Stripe-Signature: t=<ts>,v1=<hex>
+	SignatureProviderSlack   SignatureProvider = "slack"   // X-Slack-Signature: v0=<hex>, X-Slack-Request-Timestamp
+)
+
+// SignatureTolerance is the maximum allowed clock skew for timestamped
+// signature schemes (Stripe, Slack).
+const SignatureTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned when the request signature doesn't match.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Signature describes how to verify signatures of incoming requests.
+// Empty fields are filled with the defaults of the selected provider,
+// for the custom provider Header is required.
+type Signature struct {
+	Secret    string `json:"secret"` //nolint:gosec // intentional secret field
+	Header    string `json:"header,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`  // "hex" or "base64"
+	Algorithm string `json:"algorithm,omitempty"` // "sha256" or "sha1"
+}
+
+// presets contains the defaults for the body-only HMAC providers.
+var presets = map[SignatureProvider]Signature{
+	SignatureProviderCustom:  {Encoding: "hex", Algorithm: "sha256"},
+	SignatureProviderGitHub:  {Header: "X-Hub-Signature-256", Prefix: "sha256=", Encoding: "hex", Algorithm: "sha256"},
+	SignatureProviderShopify: {Header: "X-Shopify-Hmac-Sha256", Encoding: "base64", Algorithm: "sha256"},
+}
+
+// ValidateSignature checks that the signature configuration is complete.
+func (w Webhook) ValidateSignature() error {
+	switch w.SignatureProvider {
+	case SignatureProviderNone:
+		if w.Signature != nil {
+			return errors.New("signature is set, but provider is not selected")
+		}
+		return nil
+	case SignatureProviderCustom, SignatureProviderGitHub, SignatureProviderShopify,
+		SignatureProviderStripe, SignatureProviderSlack:
+	default:
+		return fmt.Errorf("unknown signature provider %q", w.SignatureProvider)
+	}
+
+	if w.Signature == nil || w.Signature.Secret == "" {
+		return errors.New("signature secret is required")
+	}
+
+	sig := w.signature()
+	if w.SignatureProvider == SignatureProviderCustom && sig.Header == "" {
+		return errors.New("signature header is required for custom provider")
+	}
+	if sig.Encoding != "hex" && sig.Encoding != "base64" {
+		return fmt.Errorf("unsupported signature encoding %q", sig.Encoding)
+	}
+	if _, err := newHash(sig.Algorithm); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifySignature verifies the signature of the incoming request against
+// the configured provider. It is a no-op if no provider is configured.
+func (w Webhook) VerifySignature(h http.Header, body []byte, now time.Time) error {
+	if w.SignatureProvider == SignatureProviderNone {
+		return nil
+	}
+	if err := w.ValidateSignature(); err != nil {
+		return fmt.Errorf("signature config: %w", err)
+	}
+
+	sig := w.signature()
+	switch w.SignatureProvider {
+	case SignatureProviderStripe:
+		return verifyStripe(sig.Secret, h.Get("Stripe-Signature"), body, now)
+	case SignatureProviderSlack:
+		return verifySlack(sig.Secret, h.Get("X-Slack-Signature"), h.Get("X-Slack-Request-Timestamp"), body, now)
+	default:
+		got := h.Get(sig.Header)
+		if got == "" {
+			return fmt.Errorf("%w: missing %s header", ErrInvalidSignature, sig.Header)
+		}
+		got, ok := strings.CutPrefix(got, sig.Prefix)
+		if !ok {
+			return fmt.Errorf("%w: missing %q prefix", ErrInvalidSignature, sig.Prefix)
+		}
+		mac, _ := newHash(sig.Algorithm) // algorithm is validated above
+		return compareMAC(mac, sig.Secret, sig.Encoding, got, body)
+	}
+}
+
+// signature returns the signature configuration with preset defaults applied.
+func (w Webhook) signature() Signature {
+	var sig Signature
+	if w.Signature != nil {
+		sig = *w.Signature
+	}
+
+	preset, ok := presets[w.SignatureProvider]
+	if !ok { // timestamped schemes have a fixed format
+		preset = Signature{Encoding: "hex", Algorithm: "sha256"}
+	}
+	if sig.Header == "" {
+		sig.Header = preset.Header
+	}
+	if sig.Prefix == "" {
+		sig.Prefix = preset.Prefix
+	}
+	if sig.Encoding == "" {
+		sig.Encoding = preset.Encoding
+	}
+	if sig.Algorithm == "" {
+		sig.Algorithm = preset.Algorithm
+	}
+	return sig
+}
+
+func verifyStripe(secret, header string, body []byte, now time.Time) error {
+	if header == "" {
+		return fmt.Errorf("%w: missing Stripe-Signature header", ErrInvalidSignature)
+	}
+
+	var ts string
+	var sigs []string
+	for part := range strings.SplitSeq(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+
+	if err := checkTimestamp(ts, now); err != nil {
+		return err
+	}
+
+	payload := append([]byte(ts+"."), body...)
+	for _, sig := range sigs {
+		if compareMAC(sha256.New, secret, "hex", sig, payload) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func verifySlack(secret, header, ts string, body []byte, now time.Time) error {
+	if err := checkTimestamp(ts, now); err != nil {
+		return err
+	}
+	sig, ok := strings.CutPrefix(header, "v0=")
+	if !ok {
+		return fmt.Errorf("%w: missing or malformed X-Slack-Signature header", ErrInvalidSignature)
+	}
+	return compareMAC(sha256.New, secret, "hex", sig, append([]byte("v0:"+ts+":"), body...))
+}
+
+func checkTimestamp(ts string, now time.Time) error {
+	if ts == "" {
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidSignature)
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp: %v", ErrInvalidSignature, err)
+	}
+	if d := now.Sub(time.Unix(sec, 0)).Abs(); d > SignatureTolerance {
+		return fmt.Errorf("%w: timestamp is outside of tolerance", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func compareMAC(fn func() hash.Hash, secret, encoding, got string, payload []byte) error {
+	var gotMAC []byte
+	var err error
+	switch encoding {
+	case "base64":
+		gotMAC, err = base64.StdEncoding.DecodeString(got)
+	default:
+		gotMAC, err = hex.DecodeString(got)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: decode: %v", ErrInvalidSignature, err)
+	}
+
+	mac := hmac.New(fn, []byte(secret))
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), gotMAC) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func newHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New, nil
+	case "sha1":
+		return sha1.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hmacSHA256(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestWebhook_VerifySignature(t *testing.T) {
+	const secret, body = "shh", `{"event":"push"}`
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	tests := []struct {
+		name     string
+		provider SignatureProvider
+		sig      *Signature
+		headers  map[string]string
+		wantErr  bool
+	}{
+		{name: "no provider", provider: SignatureProviderNone},
+		{
+			name:     "github valid",
+			provider: SignatureProviderGitHub,
+			sig:      &Signature{Secret: secret},
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256(secret, body))},
+		},
+		{
+			name:     "github wrong secret",
+			provider: SignatureProviderGitHub,
+			sig:      &Signature{Secret: secret},
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256("other", body))},
+			wantErr:  true,
+		},
+		{
+			name:     "github missing header",
+			provider: SignatureProviderGitHub,
+			sig:      &Signature{Secret: secret},
+			wantErr:  true,
+		},
+		{
+			name:     "shopify valid",
+			provider: SignatureProviderShopify,
+			sig:      &Signature{Secret: secret},
+			headers:  map[string]string{"X-Shopify-Hmac-Sha256": base64.StdEncoding.EncodeToString(hmacSHA256(secret, body))},
+		},
+		{
+			name:     "stripe valid",
+			provider: SignatureProviderStripe,
+			sig:      &Signature{Secret: secret},
+			headers: map[string]string{"Stripe-Signature": "t=" + ts + ",v1=deadbeef,v1=" +
+				hex.EncodeToString(hmacSHA256(secret, ts+"."+body))},
+		},
+		{
+			name:     "stripe expired timestamp",
+			provider: SignatureProviderStripe,
+			sig:      &Signature{Secret: secret},
+			headers: map[string]string{"Stripe-Signature": "t=1600000000,v1=" +
+				hex.EncodeToString(hmacSHA256(secret, "1600000000."+body))},
+			wantErr: true,
+		},
+		{
+			name:     "slack valid",
+			provider: SignatureProviderSlack,
+			sig:      &Signature{Secret: secret},
+			headers: map[string]string{
+				"X-Slack-Request-Timestamp": ts,
+				"X-Slack-Signature":         "v0=" + hex.EncodeToString(hmacSHA256(secret, "v0:"+ts+":"+body)),
+			},
+		},
+		{
+			name:     "custom valid",
+			provider: SignatureProviderCustom,
+			sig:      &Signature{Secret: secret, Header: "X-Sig", Encoding: "base64"},
+			headers:  map[string]string{"X-Sig": base64.StdEncoding.EncodeToString(hmacSHA256(secret, body))},
+		},
+		{
+			name:     "preset with overridden header",
+			provider: SignatureProviderGitHub,
+			sig:      &Signature{Secret: secret, Header: "X-Custom"},
+			headers:  map[string]string{"X-Custom": "sha256=" + hex.EncodeToString(hmacSHA256(secret, body))},
+		},
+		{
+			name:     "missing secret",
+			provider: SignatureProviderGitHub,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+
+			w := Webhook{SignatureProvider: tt.provider, Signature: tt.sig}
+			err := w.VerifySignature(h, []byte(body), now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestWebhook_ValidateSignature(t *testing.T) {
+	assert.NoError(t, Webhook{}.ValidateSignature())
+	assert.NoError(t, Webhook{SignatureProvider: SignatureProviderSlack, Signature: &Signature{Secret: "s"}}.ValidateSignature())
+	assert.Error(t, Webhook{SignatureProvider: "unknown", Signature: &Signature{Secret: "s"}}.ValidateSignature())
+	assert.Error(t, Webhook{SignatureProvider: SignatureProviderCustom, Signature: &Signature{Secret: "s"}}.ValidateSignature())
+	assert.Error(t, Webhook{SignatureProvider: SignatureProviderGitHub, Signature: &Signature{Secret: "s", Algorithm: "md5"}}.ValidateSignature())
+	assert.Error(t, Webhook{Signature: &Signature{Secret: "s"}}.ValidateSignature())
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Defaults of the outbound signing.
+const (
+	DefaultSigningHeader    = "X-Signature"
+	DefaultSigningAlgorithm = "sha256"
+)
+
+// OutboundSigning describes how to sign the outbound request body, so that
+// the remote can verify it was sent by remapjson. The signature is set to
+// the header as "<algorithm>=<hex HMAC of the body>".
+// Secret is a template, executed with the incoming payload.
+type OutboundSigning struct {
+	Secret    string `json:"secret"` //nolint:gosec // intentional secret field
+	Header    string `json:"header,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"` // "sha256" or "sha1"
+}
+
+// Validate checks that the secret is set and the algorithm is supported.
+func (s OutboundSigning) Validate() error {
+	if s.Secret == "" {
+		return errors.New("signing secret is required")
+	}
+	if strings.ContainsAny(s.Header, ": \t\r\n") {
+		return errors.New("invalid signing header name")
+	}
+	_, err := newHash(s.algorithm())
+	return err
+}
+
+// Sign sets the signature of the body to the header.
+func (s OutboundSigning) Sign(h http.Header, body []byte) error {
+	fn, err := newHash(s.algorithm())
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(fn, []byte(s.Secret))
+	_, _ = mac.Write(body)
+
+	header := s.Header
+	if header == "" {
+		header = DefaultSigningHeader
+	}
+	h.Set(header, s.algorithm()+"="+hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+func (s OutboundSigning) algorithm() string {
+	if s.Algorithm == "" {
+		return DefaultSigningAlgorithm
+	}
+	return s.Algorithm
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // tested algorithm
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboundSigning_Validate(t *testing.T) {
+	assert.NoError(t, OutboundSigning{Secret: "s"}.Validate())
+	assert.NoError(t, OutboundSigning{Secret: "s", Header: "X-Sig", Algorithm: "sha1"}.Validate())
+	assert.EqualError(t, OutboundSigning{}.Validate(), "signing secret is required")
+	assert.EqualError(t, OutboundSigning{Secret: "s", Header: "X Sig"}.Validate(), "invalid signing header name")
+	assert.EqualError(t, OutboundSigning{Secret: "s", Algorithm: "md5"}.Validate(), `unsupported signature algorithm "md5"`)
+}
+
+func TestOutboundSigning_Sign(t *testing.T) {
+	body := []byte(`{"text":"hello"}`)
+	hmacHex := func(fn func() hash.Hash) string {
+		mac := hmac.New(fn, []byte("secret"))
+		_, _ = mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		h := http.Header{}
+		require.NoError(t, OutboundSigning{Secret: "secret"}.Sign(h, body))
+		assert.Equal(t, "sha256="+hmacHex(sha256.New), h.Get("X-Signature"))
+	})
+
+	t.Run("custom header and sha1", func(t *testing.T) {
+		h := http.Header{}
+		require.NoError(t, OutboundSigning{Secret: "secret", Header: "X-Hub-Signature", Algorithm: "sha1"}.Sign(h, body))
+		assert.Equal(t, "sha1="+hmacHex(sha1.New), h.Get("X-Hub-Signature"))
+	})
+}
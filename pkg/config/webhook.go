@@ -0,0 +1,359 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/Semior001/remapjson/pkg/schema"
+)
+
+// JSONMode defines how the rendered JSON body is formatted before sending.
+type JSONMode string
+
+// Supported JSON modes, empty mode is the same as JSONModeAsIs.
+const (
+	JSONModeAsIs    JSONMode = "asis"    // sent as rendered
+	JSONModeCompact JSONMode = "compact" // insignificant whitespace removed
+	JSONModePretty  JSONMode = "pretty"  // indented with two spaces
+)
+
+// Webhook is the configuration of a single webhook, sealed into its token.
+type Webhook struct {
+	// URL of the remote. If empty, the webhook responds to the caller
+	// with the rendered output itself, without calling any remote.
+	URL  string `json:"url"`
+	Tmpl string `json:"tmpl"`
+
+	// SignatureProvider selects a preset scheme to verify incoming requests,
+	// Signature holds the secret and allows to override the preset defaults.
+	SignatureProvider SignatureProvider `json:"sig_provider,omitempty"`
+	Signature         *Signature        `json:"sig,omitempty"`
+
+	// AllowedMethods restricts the methods of the incoming requests,
+	// if empty, any method is accepted.
+	AllowedMethods []string `json:"methods,omitempty"`
+
+	// RequireContentType restricts the media type of the incoming requests,
+	// e.g. application/json, parameters such as charset are ignored.
+	// If empty, any content type is accepted.
+	RequireContentType string `json:"require_content_type,omitempty"`
+
+	// AllowedIPs restricts the source of the incoming requests to the
+	// listed IP addresses and CIDR networks, if empty, any source is accepted.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+
+	// RateLimit is the maximum number of requests per second
+	// accepted by this webhook, 0 means no limit.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+
+	// MaxBodyBytes is the maximum size of the incoming body in bytes,
+	// accepted by this webhook, 0 means the server-wide limit, which
+	// also caps this one.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+
+	// IncludeFields is a list of dot-separated paths of the incoming payload
+	// fields to keep, all other fields are dropped before templating.
+	// If the template is empty, the projected payload is forwarded as is.
+	IncludeFields []string `json:"include,omitempty"`
+
+	// Condition is a template executed with the incoming payload, if it
+	// renders to a falsey value, e.g. "false" or an empty string,
+	// the payload is not forwarded.
+	Condition string `json:"condition,omitempty"`
+
+	// Schema is a JSON schema the incoming payload must match,
+	// payloads failing the validation are rejected before templating.
+	Schema json.RawMessage `json:"schema,omitempty"`
+
+	// Constants is a JSON object available to the templates under
+	// the reserved _const key, e.g. for the literals repeated in them.
+	Constants json.RawMessage `json:"constants,omitempty"`
+
+	// FallbackBody, if set, is sent instead of the rendered output, when
+	// the template fails to execute, e.g. on unexpected payload shape.
+	FallbackBody string `json:"fallback_body,omitempty"`
+
+	// DoublePass makes the rendered output parsed as a template and executed
+	// with the same payload once more, e.g. to build the field names.
+	DoublePass bool `json:"double_pass,omitempty"`
+
+	// ContentType of the outbound request. If empty, application/json
+	// is used when the rendered body is valid JSON.
+	ContentType string `json:"content_type,omitempty"`
+
+	// JSONMode reformats the rendered body, if it is valid JSON,
+	// other bodies are sent as is.
+	JSONMode JSONMode `json:"json_mode,omitempty"`
+
+	// RawRequest makes the rendered output to describe the whole outbound
+	// request: the first line is "METHOD URL", followed by the headers,
+	// a blank line and the body. URL is not required in this mode.
+	RawRequest bool `json:"raw_request,omitempty"`
+
+	// Base64Body makes the rendered output to be decoded from base64
+	// to become the outbound request body, e.g. to forward binary data.
+	Base64Body bool `json:"b64_body,omitempty"`
+
+	// YAMLBody makes the rendered output, which must be valid JSON,
+	// to be converted to YAML, sent with Content-Type: application/yaml.
+	YAMLBody bool `json:"yaml_body,omitempty"`
+
+	// Multipart makes the rendered output, a JSON array of the parts,
+	// to be sent as multipart/form-data body, e.g. to upload files.
+	Multipart bool `json:"multipart,omitempty"`
+
+	// URLEncoded makes the rendered output, a JSON object of the fields,
+	// to be sent as application/x-www-form-urlencoded body.
+	URLEncoded bool `json:"urlencoded,omitempty"`
+
+	// CompressOutbound makes the outbound body, if large enough,
+	// to be sent gzipped, with Content-Encoding: gzip.
+	CompressOutbound bool `json:"gzip,omitempty"`
+
+	// IdempotencyHeader is the name of the incoming header, which identifies
+	// retries of the same request. Repeated requests with the same header
+	// value are answered with the remembered response of the remote.
+	IdempotencyHeader string `json:"idempotency_header,omitempty"`
+
+	// Delims are the left and right template action delimiters,
+	// if not set, the default "{{" and "}}" are used.
+	Delims [2]string `json:"delims,omitzero"`
+
+	// TimeoutSeconds overrides the timeout of the outbound request,
+	// 0 means the default client timeout.
+	TimeoutSeconds int `json:"timeout,omitempty"`
+
+	// ResponseHeaders restricts the remote response headers passed through
+	// to the caller, if empty, all of them are passed.
+	ResponseHeaders []string `json:"resp_headers,omitempty"`
+
+	// StatusMap replaces the remote response statuses returned to the caller,
+	// ForceStatus, if set, replaces all the statuses not listed in the map.
+	StatusMap   map[int]int `json:"status_map,omitempty"`
+	ForceStatus int         `json:"force_status,omitempty"`
+
+	// FailOnRemoteError makes the webhook respond with 502 Bad Gateway to
+	// the non-2xx remote responses, instead of passing them through.
+	FailOnRemoteError bool `json:"fail_on_remote_error,omitempty"`
+
+	// Query holds the query parameters to add to the outbound URL, values
+	// are templates, executed with the incoming payload.
+	Query map[string]string `json:"query,omitempty"`
+
+	// UserAgent of the outbound request, overrides the server default.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// Headers holds the headers to set on the outbound request, values
+	// are templates, executed with the incoming payload.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Partials are named templates, which can be invoked from the main
+	// template with {{template "name" .}}.
+	Partials map[string]string `json:"partials,omitempty"`
+
+	// Auth holds the credentials for the outbound request, if any.
+	Auth *Auth `json:"auth,omitempty"`
+
+	// OutboundSigning, if set, signs the outbound request body.
+	OutboundSigning *OutboundSigning `json:"out_sig,omitempty"`
+}
+
+// Validate checks that the webhook configuration is complete and consistent.
+func (w Webhook) Validate() error {
+	if w.RawRequest {
+		if w.Tmpl == "" {
+			return errors.New("missing template of the raw request")
+		}
+		if w.Base64Body {
+			return errors.New("raw request can't be decoded from base64")
+		}
+		if w.YAMLBody {
+			return errors.New("raw request can't be converted to YAML")
+		}
+		if w.Multipart {
+			return errors.New("raw request can't be sent as multipart form")
+		}
+		if w.URLEncoded {
+			return errors.New("raw request can't be sent as url-encoded form")
+		}
+	} else if w.Tmpl == "" && len(w.IncludeFields) == 0 {
+		return errors.New("missing template")
+	}
+	if w.Base64Body && w.YAMLBody {
+		return errors.New("body decoded from base64 can't be converted to YAML")
+	}
+	if w.Multipart && (w.Base64Body || w.YAMLBody) {
+		return errors.New("multipart form is built from JSON, it can't be decoded from base64 or converted to YAML")
+	}
+	if w.Multipart && w.Local() {
+		return errors.New("multipart form can be sent only to the target URL")
+	}
+	if w.URLEncoded && (w.Base64Body || w.YAMLBody || w.Multipart) {
+		return errors.New("url-encoded form is built from JSON, it can't be decoded from base64, converted to YAML or sent as multipart form")
+	}
+	if w.URLEncoded && w.Local() {
+		return errors.New("url-encoded form can be sent only to the target URL")
+	}
+	for _, method := range w.AllowedMethods {
+		if method == "" || strings.TrimFunc(method, func(r rune) bool { return r >= 'A' && r <= 'Z' }) != "" {
+			return fmt.Errorf("invalid allowed method %q", method)
+		}
+	}
+	if w.RequireContentType != "" {
+		if _, _, err := mime.ParseMediaType(w.RequireContentType); err != nil {
+			return fmt.Errorf("invalid required content type %q: %w", w.RequireContentType, err)
+		}
+	}
+	for _, ip := range w.AllowedIPs {
+		if _, err := parsePrefix(ip); err != nil {
+			return fmt.Errorf("invalid allowed IP %q: %w", ip, err)
+		}
+	}
+	if w.RateLimit < 0 {
+		return fmt.Errorf("rate limit must be non-negative, got %v", w.RateLimit)
+	}
+	if math.IsNaN(w.RateLimit) || math.IsInf(w.RateLimit, 0) {
+		return fmt.Errorf("rate limit must be a finite number, got %v", w.RateLimit)
+	}
+	if w.MaxBodyBytes < 0 {
+		return fmt.Errorf("max body bytes must be non-negative, got %d", w.MaxBodyBytes)
+	}
+	if w.TimeoutSeconds < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %d", w.TimeoutSeconds)
+	}
+	for _, path := range w.IncludeFields {
+		if slices.Contains(strings.Split(path, "."), "") {
+			return fmt.Errorf("invalid include field path %q", path)
+		}
+	}
+	for name := range w.Query {
+		if name == "" {
+			return errors.New("query parameter name is required")
+		}
+	}
+	if w.ForceStatus != 0 && !validStatus(w.ForceStatus) {
+		return fmt.Errorf("invalid forced status %d", w.ForceStatus)
+	}
+	for from, to := range w.StatusMap {
+		if !validStatus(from) || !validStatus(to) {
+			return fmt.Errorf("invalid status mapping %d->%d", from, to)
+		}
+	}
+	for name := range w.Headers {
+		if name == "" || strings.ContainsAny(name, ": \t\r\n") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for name := range w.Partials {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("partial name is required")
+		}
+	}
+	if (w.Delims[0] == "") != (w.Delims[1] == "") {
+		return errors.New("both left and right template delimiters must be set")
+	}
+	if len(w.Schema) > 0 {
+		if _, err := schema.Compile(w.Schema); err != nil {
+			return fmt.Errorf("invalid schema: %w", err)
+		}
+	}
+	if len(w.Constants) > 0 {
+		var consts map[string]any
+		if err := json.Unmarshal(w.Constants, &consts); err != nil || consts == nil {
+			return errors.New("constants must be a JSON object")
+		}
+	}
+	switch w.JSONMode {
+	case "", JSONModeAsIs, JSONModeCompact, JSONModePretty:
+	default:
+		return fmt.Errorf("unknown JSON mode %q", w.JSONMode)
+	}
+	if w.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(w.ContentType); err != nil {
+			return fmt.Errorf("invalid content type %q: %w", w.ContentType, err)
+		}
+	}
+	if err := w.ValidateSignature(); err != nil {
+		return fmt.Errorf("invalid signature config: %w", err)
+	}
+	if w.Auth != nil {
+		if err := w.Auth.Validate(); err != nil {
+			return fmt.Errorf("invalid auth config: %w", err)
+		}
+	}
+	if w.OutboundSigning != nil {
+		if err := w.OutboundSigning.Validate(); err != nil {
+			return fmt.Errorf("invalid outbound signing config: %w", err)
+		}
+	}
+	return nil
+}
+
+// AllowsMethod reports whether the incoming requests with the method
+// are accepted by the webhook.
+func (w Webhook) AllowsMethod(method string) bool {
+	return len(w.AllowedMethods) == 0 || slices.Contains(w.AllowedMethods, method)
+}
+
+// AcceptsContentType reports whether the webhook accepts the requests
+// with the Content-Type header, comparing the media types only.
+func (w Webhook) AcceptsContentType(header string) bool {
+	if w.RequireContentType == "" {
+		return true
+	}
+	got, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	want, _, err := mime.ParseMediaType(w.RequireContentType)
+	return err == nil && got == want // both are lowercased by parsing
+}
+
+// AllowsIP reports whether the webhook accepts the requests from the address.
+func (w Webhook) AllowsIP(addr netip.Addr) bool {
+	if len(w.AllowedIPs) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(w.AllowedIPs, func(ip string) bool {
+		prefix, err := parsePrefix(ip)
+		return err == nil && prefix.Contains(addr)
+	})
+}
+
+// parsePrefix parses the CIDR network, or the single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// Local reports whether the webhook responds with the rendered output
+// itself, as it has no remote to forward it to.
+func (w Webhook) Local() bool { return w.URL == "" && !w.RawRequest }
+
+// Status returns the status to respond to the caller with
+// for the status of the remote response.
+func (w Webhook) Status(remote int) int {
+	if status, ok := w.StatusMap[remote]; ok {
+		return status
+	}
+	if w.ForceStatus != 0 {
+		return w.ForceStatus
+	}
+	return remote
+}
+
+func validStatus(code int) bool { return code >= 100 && code <= 599 }
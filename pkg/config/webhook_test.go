@@ -0,0 +1,239 @@
+package config
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Webhook
+		wantErr string
+	}{
+		{name: "url and template", cfg: Webhook{URL: "http://example.com", Tmpl: "{{.v}}"}},
+		{name: "url and include fields", cfg: Webhook{URL: "http://example.com", IncludeFields: []string{"a.b", "c"}}},
+		{name: "raw request without url", cfg: Webhook{Tmpl: "POST https://example.com\n\n{}", RawRequest: true}},
+		{name: "raw request without template", cfg: Webhook{RawRequest: true}, wantErr: "missing template of the raw request"},
+		{
+			name:    "raw request in base64",
+			cfg:     Webhook{Tmpl: "x", RawRequest: true, Base64Body: true},
+			wantErr: "raw request can't be decoded from base64",
+		},
+		{
+			name:    "raw request in YAML",
+			cfg:     Webhook{Tmpl: "x", RawRequest: true, YAMLBody: true},
+			wantErr: "raw request can't be converted to YAML",
+		},
+		{
+			name:    "base64 body in YAML",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "x", Base64Body: true, YAMLBody: true},
+			wantErr: "body decoded from base64 can't be converted to YAML",
+		},
+		{name: "local response without url", cfg: Webhook{Tmpl: "{{.v}}"}},
+		{name: "compact JSON", cfg: Webhook{URL: "http://example.com", Tmpl: "{{.v}}", JSONMode: JSONModeCompact}},
+		{name: "unknown JSON mode", cfg: Webhook{URL: "http://example.com", Tmpl: "{{.v}}", JSONMode: "indent"}, wantErr: `unknown JSON mode "indent"`},
+		{name: "missing template", cfg: Webhook{URL: "http://example.com"}, wantErr: "missing template"},
+		{name: "local response without template", cfg: Webhook{}, wantErr: "missing template"},
+		{
+			name:    "negative rate limit",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", RateLimit: -1},
+			wantErr: "rate limit must be non-negative, got -1",
+		},
+		{
+			name:    "NaN rate limit",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", RateLimit: math.NaN()},
+			wantErr: "rate limit must be a finite number, got NaN",
+		},
+		{
+			name:    "infinite rate limit",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", RateLimit: math.Inf(1)},
+			wantErr: "rate limit must be a finite number, got +Inf",
+		},
+		{
+			name:    "negative infinite rate limit",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", RateLimit: math.Inf(-1)},
+			wantErr: "rate limit must be non-negative, got -Inf",
+		},
+		{
+			name:    "negative max body bytes",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", MaxBodyBytes: -1},
+			wantErr: "max body bytes must be non-negative, got -1",
+		},
+		{
+			name:    "invalid required content type",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", RequireContentType: "application/"},
+			wantErr: `invalid required content type "application/": mime: expected token after slash`,
+		},
+		{name: "multipart", cfg: Webhook{URL: "http://example.com", Tmpl: `[{"name": "a", "value": "{{.v}}"}]`, Multipart: true}},
+		{
+			name:    "multipart without target URL",
+			cfg:     Webhook{Tmpl: `[{"name": "a", "value": "{{.v}}"}]`, Multipart: true},
+			wantErr: "multipart form can be sent only to the target URL",
+		},
+		{
+			name:    "multipart raw request",
+			cfg:     Webhook{Tmpl: "POST http://example.com\n\n{{.v}}", RawRequest: true, Multipart: true},
+			wantErr: "raw request can't be sent as multipart form",
+		},
+		{
+			name:    "multipart YAML",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Multipart: true, YAMLBody: true},
+			wantErr: "multipart form is built from JSON, it can't be decoded from base64 or converted to YAML",
+		},
+		{name: "url-encoded", cfg: Webhook{URL: "http://example.com", Tmpl: `{"a": "{{.v}}"}`, URLEncoded: true}},
+		{
+			name:    "url-encoded without target URL",
+			cfg:     Webhook{Tmpl: `{"a": "{{.v}}"}`, URLEncoded: true},
+			wantErr: "url-encoded form can be sent only to the target URL",
+		},
+		{
+			name:    "url-encoded raw request",
+			cfg:     Webhook{Tmpl: "POST http://example.com\n\n{{.v}}", RawRequest: true, URLEncoded: true},
+			wantErr: "raw request can't be sent as url-encoded form",
+		},
+		{
+			name:    "url-encoded multipart",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", URLEncoded: true, Multipart: true},
+			wantErr: "url-encoded form is built from JSON, it can't be decoded from base64, converted to YAML or sent as multipart form",
+		},
+		{name: "allowed IPs", cfg: Webhook{URL: "http://example.com", Tmpl: "{{.v}}", AllowedIPs: []string{"10.0.0.0/8", "::1"}}},
+		{
+			name:    "invalid allowed IP",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", AllowedIPs: []string{"10.0.0.0/33"}},
+			wantErr: `invalid allowed IP "10.0.0.0/33": netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`,
+		},
+		{name: "allowed methods", cfg: Webhook{URL: "http://example.com", Tmpl: "{{.v}}", AllowedMethods: []string{"POST", "PUT"}}},
+		{
+			name:    "invalid allowed method",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", AllowedMethods: []string{"post"}},
+			wantErr: `invalid allowed method "post"`,
+		},
+		{
+			name:    "negative timeout",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", TimeoutSeconds: -1},
+			wantErr: "timeout must be non-negative, got -1",
+		},
+		{
+			name:    "unnamed query parameter",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Query: map[string]string{"": "{{.id}}"}},
+			wantErr: "query parameter name is required",
+		},
+		{name: "constants", cfg: Webhook{URL: "http://example.com", Tmpl: "{{._const.v}}", Constants: []byte(`{"v": 1}`)}},
+		{
+			name:    "constants not an object",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Constants: []byte(`[1, 2]`)},
+			wantErr: "constants must be a JSON object",
+		},
+		{
+			name:    "invalid forced status",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", ForceStatus: 42},
+			wantErr: "invalid forced status 42",
+		},
+		{
+			name:    "invalid status mapping",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", StatusMap: map[int]int{502: 2000}},
+			wantErr: "invalid status mapping 502->2000",
+		},
+		{
+			name:    "invalid header name",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Headers: map[string]string{"X Source": "x"}},
+			wantErr: `invalid header name "X Source"`,
+		},
+		{
+			name:    "unnamed partial",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Partials: map[string]string{"": "x"}},
+			wantErr: "partial name is required",
+		},
+		{
+			name:    "single delimiter",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "<<.v}}", Delims: [2]string{"<<", ""}},
+			wantErr: "both left and right template delimiters must be set",
+		},
+		{
+			name:    "invalid content type",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", ContentType: "application/"},
+			wantErr: `invalid content type "application/": mime: expected token after slash`,
+		},
+		{
+			name:    "invalid auth",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Auth: &Auth{Type: AuthTypeBearer}},
+			wantErr: "invalid auth config: bearer token is required",
+		},
+		{
+			name: "invalid outbound signing",
+			cfg: Webhook{URL: "http://example.com", Tmpl: "{{.v}}",
+				OutboundSigning: &OutboundSigning{Secret: "s", Algorithm: "md5"}},
+			wantErr: `invalid outbound signing config: unsupported signature algorithm "md5"`,
+		},
+		{
+			name:    "invalid schema",
+			cfg:     Webhook{URL: "http://example.com", Tmpl: "{{.v}}", Schema: []byte(`{"type":"object","$ref":"https://example.com/s.json"}`)},
+			wantErr: `invalid schema: compile schema: failing loading "https://example.com/s.json": external references are not allowed, got "https://example.com/s.json"`,
+		},
+		{
+			name:    "empty path segment",
+			cfg:     Webhook{URL: "http://example.com", IncludeFields: []string{"a..b"}},
+			wantErr: `invalid include field path "a..b"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestWebhook_Status(t *testing.T) {
+	assert.Equal(t, 502, Webhook{}.Status(502))
+
+	w := Webhook{StatusMap: map[int]int{502: 202}}
+	assert.Equal(t, 202, w.Status(502))
+	assert.Equal(t, 500, w.Status(500))
+
+	w.ForceStatus = 200
+	assert.Equal(t, 202, w.Status(502), "mapping takes precedence")
+	assert.Equal(t, 200, w.Status(500))
+}
+
+func TestWebhook_AcceptsContentType(t *testing.T) {
+	assert.True(t, Webhook{}.AcceptsContentType(""), "any content type is accepted by default")
+
+	w := Webhook{RequireContentType: "application/json"}
+	assert.True(t, w.AcceptsContentType("application/json"))
+	assert.True(t, w.AcceptsContentType("Application/JSON; charset=utf-8"))
+	assert.False(t, w.AcceptsContentType("text/plain"))
+	assert.False(t, w.AcceptsContentType("application/json-patch+json"))
+	assert.False(t, w.AcceptsContentType(""))
+	assert.False(t, w.AcceptsContentType("application/json; charset"), "malformed")
+}
+
+func TestWebhook_AllowsIP(t *testing.T) {
+	assert.True(t, Webhook{}.AllowsIP(netip.MustParseAddr("203.0.113.7")), "any source is allowed by default")
+
+	w := Webhook{AllowedIPs: []string{"192.30.252.0/22", "203.0.113.7", "2001:db8::/32"}}
+	assert.True(t, w.AllowsIP(netip.MustParseAddr("192.30.253.1")))
+	assert.True(t, w.AllowsIP(netip.MustParseAddr("203.0.113.7")))
+	assert.True(t, w.AllowsIP(netip.MustParseAddr("::ffff:203.0.113.7")), "IPv4-mapped")
+	assert.True(t, w.AllowsIP(netip.MustParseAddr("2001:db8::1")))
+	assert.False(t, w.AllowsIP(netip.MustParseAddr("203.0.113.8")))
+	assert.False(t, w.AllowsIP(netip.Addr{}))
+}
+
+func TestWebhook_AllowsMethod(t *testing.T) {
+	assert.True(t, Webhook{}.AllowsMethod("DELETE"), "any method is allowed by default")
+
+	w := Webhook{AllowedMethods: []string{"POST", "PUT"}}
+	assert.True(t, w.AllowsMethod("POST"))
+	assert.True(t, w.AllowsMethod("PUT"))
+	assert.False(t, w.AllowsMethod("GET"))
+}
@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/cappuccinotm/slogx"
+)
+
+// accessRecord is a single line of the access log. It must never contain
+// the decrypted configuration or the payload, only the token fingerprint.
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Token      string    `json:"token"`
+	Method     string    `json:"method"`
+	RemoteHost string    `json:"remote_host,omitempty"`
+	Status     int       `json:"status"`
+	LatencyMS  int64     `json:"latency_ms"`
+}
+
+// accessWriter captures the response status and the remote host
+// of the webhook delivery for the access log.
+type accessWriter struct {
+	http.ResponseWriter
+	status     int
+	remoteHost string
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *accessWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// setRemoteHost records the remote host for the access log
+// and the delivery receipt, if enabled.
+func setRemoteHost(w http.ResponseWriter, host string) {
+	for {
+		switch rw := w.(type) {
+		case *accessWriter:
+			rw.remoteHost = host
+			w = rw.ResponseWriter
+		case *receiptWriter:
+			rw.remoteHost = host
+			w = rw.ResponseWriter
+		case *flightWriter:
+			rw.remoteHost = host
+			w = rw.ResponseWriter
+		default:
+			return
+		}
+	}
+}
+
+// accessLog wraps the webhook handler to write a record per delivery
+// to the access log, if enabled.
+func (s *Server) accessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.AccessLog == nil {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		next(aw, r)
+
+		rec := accessRecord{
+			Time:       start.UTC(),
+			RequestID:  r.Header.Get("X-Request-ID"),
+			Token:      config.Fingerprint(r.PathValue("token")),
+			Method:     r.Method,
+			RemoteHost: aw.remoteHost,
+			Status:     aw.status,
+			LatencyMS:  time.Since(start).Milliseconds(),
+		}
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+
+		b, err := json.Marshal(rec)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to encode access log record", slogx.Error(err))
+			return
+		}
+
+		s.accessLogMu.Lock()
+		defer s.accessLogMu.Unlock()
+		if _, err = s.AccessLog.Write(append(b, '\n')); err != nil {
+			slog.WarnContext(r.Context(), "failed to write access log record", slogx.Error(err))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_accessLog(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer remote.Close()
+
+	buf := &bytes.Buffer{}
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), AccessLog: buf}
+	handler := s.accessLog(s.handleWebhook)
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"secret-template": "{{.value}}"}`})
+	require.NoError(t, err)
+
+	handler(httptest.NewRecorder(), webhookRequest(http.MethodPost, token, `{"value":"secret-payload"}`))
+	handler(httptest.NewRecorder(), webhookRequest(http.MethodPost, "invalid", `{}`))
+
+	assert.NotContains(t, buf.String(), "secret-template")
+	assert.NotContains(t, buf.String(), "secret-payload")
+	assert.NotContains(t, buf.String(), token)
+
+	remoteURL, err := url.Parse(remote.URL)
+	require.NoError(t, err)
+
+	dec := json.NewDecoder(buf)
+	var rec accessRecord
+	require.NoError(t, dec.Decode(&rec))
+	assert.Equal(t, config.Fingerprint(token), rec.Token)
+	assert.Equal(t, http.MethodPost, rec.Method)
+	assert.Equal(t, remoteURL.Host, rec.RemoteHost)
+	assert.Equal(t, http.StatusAccepted, rec.Status)
+	assert.False(t, rec.Time.IsZero())
+
+	rec = accessRecord{}
+	require.NoError(t, dec.Decode(&rec))
+	assert.Equal(t, config.Fingerprint("invalid"), rec.Token)
+	assert.Empty(t, rec.RemoteHost)
+	assert.Equal(t, http.StatusBadRequest, rec.Status)
+
+	assert.False(t, dec.More(), "one record per delivery")
+}
@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/Semior001/remapjson/pkg/store"
+	"github.com/cappuccinotm/slogx"
+)
+
+// bundle is an exported set of configured webhooks.
+type bundle struct {
+	Webhooks []bundleItem `json:"webhooks"`
+}
+
+type bundleItem struct {
+	ID        string          `json:"id"`
+	Token     string          `json:"token"`
+	CreatedAt time.Time       `json:"created_at"`
+	Config    *config.Webhook `json:"config,omitempty"` // plaintext, only if requested
+}
+
+// Limits of the stored webhooks listing.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// listedWebhook is a stored webhook in the listing. Only the host of the
+// target is shown, as the full URL and template may contain secrets.
+type listedWebhook struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET /configure - lists the stored webhooks, newest first.
+// Accepts ?limit (defaults to 50, up to 500) and ?offset query parameters.
+// Responds with an HTML table to HTMX requests and with JSON otherwise.
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, offset, err := pageFromQuery(r.URL.Query())
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+		return
+	}
+
+	items, err := s.Store.List(ctx)
+	if err != nil {
+		s.error(w, r, http.StatusInternalServerError, codeInternal, "failed to list webhooks: %v", err)
+		return
+	}
+	slices.Reverse(items)
+
+	var resp struct {
+		Total    int             `json:"total"`
+		Limit    int             `json:"limit"`
+		Offset   int             `json:"offset"`
+		Webhooks []listedWebhook `json:"webhooks"`
+	}
+	resp.Total, resp.Limit, resp.Offset = len(items), limit, offset
+	resp.Webhooks = []listedWebhook{}
+
+	for _, item := range items[min(offset, len(items)):min(offset+limit, len(items))] {
+		lw := listedWebhook{ID: item.ID, CreatedAt: item.CreatedAt}
+		if cfg, uerr := s.Sealer.Unseal(item.Token); uerr != nil {
+			slog.WarnContext(ctx, "failed to unseal stored webhook, listing without host",
+				slog.String("id", item.ID), slogx.Error(uerr))
+		} else if u, perr := url.Parse(cfg.URL); perr == nil {
+			lw.Host = u.Host
+		}
+		resp.Webhooks = append(resp.Webhooks, lw)
+	}
+
+	if !wantsJSON(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<table class="webhooks"><thead><tr><th>ID</th><th>Host</th><th>Created</th></tr></thead><tbody>`)
+		for _, lw := range resp.Webhooks {
+			//nolint:gosec // values are escaped with html.EscapeString
+			fmt.Fprintf(w, `<tr><td>%s</td><td>%s</td><td>%s</td></tr>`, html.EscapeString(lw.ID),
+				html.EscapeString(lw.Host), lw.CreatedAt.Format(time.DateTime))
+		}
+		fmt.Fprintf(w, `</tbody></table><div class="pager">%d–%d of %d`,
+			min(offset+1, resp.Total), offset+len(resp.Webhooks), resp.Total)
+		if offset > 0 {
+			fmt.Fprintf(w, ` <button class="btn-copy" hx-get="../configure?limit=%d&offset=%d" hx-target="#stored-webhooks">Newer</button>`,
+				limit, max(offset-limit, 0))
+		}
+		if offset+limit < resp.Total {
+			fmt.Fprintf(w, ` <button class="btn-copy" hx-get="../configure?limit=%d&offset=%d" hx-target="#stored-webhooks">Older</button>`,
+				limit, offset+limit)
+		}
+		fmt.Fprint(w, `</div>`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// pageFromQuery returns the limit and offset of the listing from the query.
+func pageFromQuery(q url.Values) (limit, offset int, err error) {
+	limit = defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q, expected a positive number", v)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q, expected a non-negative number", v)
+		}
+	}
+	return min(limit, maxListLimit), offset, nil
+}
+
+// GET /admin/export - returns all stored webhooks as an importable bundle.
+// With ?plaintext=true, the unsealed configurations are included, which allows
+// to import the bundle into an instance with a different secret.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	items, err := s.Store.List(ctx)
+	if err != nil {
+		s.error(w, r, http.StatusInternalServerError, codeInternal, "failed to list webhooks: %v", err)
+		return
+	}
+
+	plaintext := r.URL.Query().Get("plaintext") == "true"
+
+	resp := bundle{Webhooks: make([]bundleItem, 0, len(items))}
+	for _, item := range items {
+		bi := bundleItem{ID: item.ID, Token: item.Token, CreatedAt: item.CreatedAt}
+		if plaintext {
+			cfg, uerr := s.Sealer.Unseal(item.Token)
+			if uerr != nil {
+				slog.WarnContext(ctx, "failed to unseal stored webhook, exporting without config",
+					slog.String("id", item.ID), slogx.Error(uerr))
+			} else {
+				bi.Config = &cfg
+			}
+		}
+		resp.Webhooks = append(resp.Webhooks, bi)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="remapjson-export.json"`)
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// POST /admin/import - loads webhooks from the bundle made by /admin/export.
+// Tokens that can't be unsealed with the current secret are re-sealed from
+// the plaintext config, if the bundle provides it, otherwise they are reported
+// as failed.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req bundle
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid bundle: %v", err)
+		return
+	}
+
+	type itemError struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+
+	var resp struct {
+		Imported int         `json:"imported"`
+		Resealed int         `json:"resealed"`
+		Errors   []itemError `json:"errors,omitempty"`
+	}
+
+	for _, item := range req.Webhooks {
+		wh := store.Webhook{Token: item.Token, CreatedAt: item.CreatedAt}
+		if wh.CreatedAt.IsZero() {
+			wh.CreatedAt = time.Now()
+		}
+
+		if _, err := s.Sealer.Unseal(item.Token); err != nil {
+			if item.Config == nil {
+				resp.Errors = append(resp.Errors, itemError{ID: item.ID, Error: "token doesn't match the current secret " +
+					"and the bundle has no plaintext config: " + err.Error()})
+				continue
+			}
+
+			// the plaintext is checked as on POST /configure, e.g. against the allowed schemes
+			if err = s.checkWebhook(*item.Config); err != nil {
+				resp.Errors = append(resp.Errors, itemError{ID: item.ID, Error: "invalid plaintext config: " + err.Error()})
+				continue
+			}
+			if wh.Token, err = s.Sealer.Seal(*item.Config); err != nil {
+				resp.Errors = append(resp.Errors, itemError{ID: item.ID, Error: "failed to reseal: " + err.Error()})
+				continue
+			}
+			resp.Resealed++
+		}
+
+		wh.ID = config.Fingerprint(wh.Token)
+
+		if err := s.Store.Put(ctx, wh); err != nil {
+			resp.Errors = append(resp.Errors, itemError{ID: item.ID, Error: "failed to store: " + err.Error()})
+			continue
+		}
+		resp.Imported++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// cacheStats reports the state of the parsed templates cache.
+type cacheStats struct {
+	Templates    int `json:"templates"`
+	MaxTemplates int `json:"max_templates"` // 0 means no limit
+	Hits         int `json:"hits"`
+	Misses       int `json:"misses"`
+	Evicted      int `json:"evicted"`
+}
+
+// GET /admin/cache - reports the number of cached templates and the
+// effectiveness of the cache since the start.
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	c := s.templateCache()
+	stat := c.Stat()
+	resp := cacheStats{Templates: c.Len(), MaxTemplates: s.TemplateCacheSize,
+		Hits: stat.Hits, Misses: stat.Misses, Evicted: stat.Evicted}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(r.Context(), "failed to write response", slogx.Error(err))
+	}
+}
+
+// DELETE /admin/cache - drops all the cached templates, configurations
+// and schemas, they are parsed again on the next use.
+func (s *Server) handleCacheClear(w http.ResponseWriter, r *http.Request) {
+	n := s.templateCache().Len()
+	s.templateCache().Purge()
+	s.configs.Purge()
+	s.schemas.Purge()
+	slog.InfoContext(r.Context(), "cleared template cache", slog.Int("templates", n))
+	w.WriteHeader(http.StatusNoContent)
+}
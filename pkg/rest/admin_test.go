@@ -0,0 +1,248 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/Semior001/remapjson/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFileStore(t *testing.T) *store.File {
+	t.Helper()
+	st, err := store.NewFile(filepath.Join(t.TempDir(), "webhooks.json"))
+	require.NoError(t, err)
+	return st
+}
+
+func TestServer_ExportImport(t *testing.T) {
+	src := &Server{BaseURL: "http://localhost:8080", Sealer: config.Sealer{Secret: "secret-a"}, Store: newFileStore(t)}
+
+	rec := httptest.NewRecorder()
+	src.handleConfigure(rec, configureRequest("https://example.com/hook", "{{.value}}"))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	export := func(t *testing.T, query string) string {
+		rec := httptest.NewRecorder()
+		src.handleExport(rec, httptest.NewRequest(http.MethodGet, "/admin/export"+query, http.NoBody))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	importBundle := func(t *testing.T, dst *Server, body string) (resp struct {
+		Imported int               `json:"imported"`
+		Resealed int               `json:"resealed"`
+		Errors   []json.RawMessage `json:"errors"`
+	}) {
+		rec := httptest.NewRecorder()
+		dst.handleImport(rec, httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	t.Run("export without plaintext has no configs", func(t *testing.T) {
+		var b bundle
+		require.NoError(t, json.Unmarshal([]byte(export(t, "")), &b))
+		require.Len(t, b.Webhooks, 1)
+		assert.Nil(t, b.Webhooks[0].Config)
+		assert.NotEmpty(t, b.Webhooks[0].Token)
+	})
+
+	t.Run("import into instance with the same secret", func(t *testing.T) {
+		dst := &Server{Sealer: config.Sealer{Secret: "secret-a"}, Store: newFileStore(t)}
+		resp := importBundle(t, dst, export(t, ""))
+		assert.Equal(t, 1, resp.Imported)
+		assert.Zero(t, resp.Resealed)
+		assert.Empty(t, resp.Errors)
+
+		list, err := dst.Store.List(t.Context())
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+	})
+
+	t.Run("import into instance with other secret fails without plaintext", func(t *testing.T) {
+		dst := &Server{Sealer: config.Sealer{Secret: "secret-b"}, Store: newFileStore(t)}
+		resp := importBundle(t, dst, export(t, ""))
+		assert.Zero(t, resp.Imported)
+		assert.Len(t, resp.Errors, 1)
+	})
+
+	t.Run("import into instance with other secret reseals plaintext", func(t *testing.T) {
+		dst := &Server{Sealer: config.Sealer{Secret: "secret-b"}, Store: newFileStore(t)}
+		resp := importBundle(t, dst, export(t, "?plaintext=true"))
+		assert.Equal(t, 1, resp.Imported)
+		assert.Equal(t, 1, resp.Resealed)
+		assert.Empty(t, resp.Errors)
+
+		list, err := dst.Store.List(t.Context())
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+
+		cfg, err := dst.Sealer.Unseal(list[0].Token)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/hook", cfg.URL)
+		assert.Equal(t, "{{.value}}", cfg.Tmpl)
+	})
+
+	t.Run("plaintext rejected by the instance is not resealed", func(t *testing.T) {
+		dst := &Server{Sealer: config.Sealer{Secret: "secret-b"}, Store: newFileStore(t), AllowedSchemes: []string{"ftp"}}
+		resp := importBundle(t, dst, export(t, "?plaintext=true"))
+		assert.Zero(t, resp.Imported)
+		assert.Zero(t, resp.Resealed)
+		require.Len(t, resp.Errors, 1)
+		assert.Contains(t, string(resp.Errors[0]), `invalid plaintext config: URL scheme \"https\" is not allowed`)
+
+		list, err := dst.Store.List(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+}
+
+func TestServer_handleList(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Sealer: config.Sealer{Secret: "secret"}, Store: newFileStore(t)}
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		token, err := s.Sealer.Seal(config.Webhook{URL: fmt.Sprintf("https://host%d.example.com/hook?key=secret", i), Tmpl: "{{.v}}"})
+		require.NoError(t, err)
+		require.NoError(t, s.Store.Put(t.Context(), store.Webhook{ID: config.Fingerprint(token), Token: token,
+			CreatedAt: base.Add(time.Duration(i) * time.Hour)}))
+	}
+
+	list := func(t *testing.T, query string) (resp struct {
+		Total    int             `json:"total"`
+		Limit    int             `json:"limit"`
+		Offset   int             `json:"offset"`
+		Webhooks []listedWebhook `json:"webhooks"`
+	}) {
+		rec := httptest.NewRecorder()
+		s.handleList(rec, httptest.NewRequest(http.MethodGet, "/configure"+query, http.NoBody))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "secret")
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	t.Run("newest first with defaults", func(t *testing.T) {
+		resp := list(t, "")
+		assert.Equal(t, 3, resp.Total)
+		assert.Equal(t, defaultListLimit, resp.Limit)
+		require.Len(t, resp.Webhooks, 3)
+		assert.Equal(t, "host2.example.com", resp.Webhooks[0].Host)
+		assert.Equal(t, "host0.example.com", resp.Webhooks[2].Host)
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		resp := list(t, "?limit=2&offset=1")
+		require.Len(t, resp.Webhooks, 2)
+		assert.Equal(t, "host1.example.com", resp.Webhooks[0].Host)
+		assert.Equal(t, base, resp.Webhooks[1].CreatedAt)
+
+		resp = list(t, "?offset=10")
+		assert.Equal(t, 3, resp.Total)
+		assert.Empty(t, resp.Webhooks)
+	})
+
+	t.Run("invalid page", func(t *testing.T) {
+		for _, query := range []string{"?limit=0", "?limit=x", "?offset=-1"} {
+			rec := httptest.NewRecorder()
+			s.handleList(rec, httptest.NewRequest(http.MethodGet, "/configure"+query, http.NoBody))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		}
+	})
+
+	t.Run("html table for htmx", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/configure?limit=1&offset=1", http.NoBody)
+		req.Header.Set("HX-Request", "true")
+		rec := httptest.NewRecorder()
+		s.handleList(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, "<td>host1.example.com</td>")
+		assert.Contains(t, body, "2–2 of 3")
+		assert.Contains(t, body, `hx-get="../configure?limit=1&offset=0"`)
+		assert.Contains(t, body, `hx-get="../configure?limit=1&offset=2"`)
+	})
+}
+
+func TestServer_templateCache(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Password: "pass", TemplateCacheSize: 2}
+	h := s.routes(webFS)
+
+	stats := func() (resp cacheStats) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/cache", http.NoBody)
+		req.SetBasicAuth("remapjson", "pass")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	for i := range 3 {
+		_, err := s.template(config.Webhook{Tmpl: fmt.Sprintf("{{.v%d}}", i)})
+		require.NoError(t, err)
+	}
+	_, err := s.template(config.Webhook{Tmpl: "{{.v2}}"})
+	require.NoError(t, err)
+
+	assert.Equal(t, cacheStats{Templates: 2, MaxTemplates: 2, Hits: 1, Misses: 3, Evicted: 1}, stats())
+
+	t.Run("requires auth", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/cache", http.NoBody))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("unsealed configurations are kept", func(t *testing.T) {
+		sealer := &countingSealer{Sealer: config.Sealer{Secret: "test-secret"}}
+		s := &Server{Sealer: sealer, TemplateCacheSize: 2}
+
+		token, err := sealer.Seal(config.Webhook{URL: "https://example.com", Tmpl: "{{.v}}"})
+		require.NoError(t, err)
+
+		for range 3 {
+			cfg, err := s.unseal(token)
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.com", cfg.URL)
+		}
+		assert.Equal(t, 1, sealer.unsealed)
+
+		_, err = s.unseal("invalid")
+		require.Error(t, err)
+		_, err = s.unseal("invalid")
+		require.Error(t, err)
+		assert.Equal(t, 3, sealer.unsealed, "invalid tokens are not cached")
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/cache", http.NoBody)
+		req.SetBasicAuth("remapjson", "pass")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, 0, stats().Templates)
+	})
+}
+
+// countingSealer counts the tokens it unsealed.
+type countingSealer struct {
+	config.Sealer
+	unsealed int
+}
+
+func (s *countingSealer) Unseal(token string) (config.Webhook, error) {
+	s.unsealed++
+	return s.Sealer.Unseal(token)
+}
@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// maxLoggedBody is the maximum size of the logged body, the rest is truncated.
+const maxLoggedBody = 4 * 1024
+
+// defaultRedactFields are the names of the fields masked in logged bodies.
+var defaultRedactFields = []string{"password", "passwd", "secret", "token", "api_key", "apikey", "authorization"}
+
+// logBodies logs the decoded incoming payload and the rendered outbound
+// body with sensitive fields masked, if enabled.
+func (s *Server) logBodies(ctx context.Context, inbound any, outbound []byte) {
+	if !s.Debug || !s.LogBodies {
+		return
+	}
+
+	fields := s.RedactFields
+	if len(fields) == 0 {
+		fields = defaultRedactFields
+	}
+
+	in, _ := json.Marshal(redact(inbound, fields)) // decoded from JSON or form, so always encodable
+
+	out := string(outbound)
+	var data any
+	if err := json.Unmarshal(outbound, &data); err == nil {
+		b, _ := json.Marshal(redact(data, fields))
+		out = string(b)
+	}
+
+	slog.DebugContext(ctx, "webhook bodies",
+		slog.String("inbound", truncate(string(in), maxLoggedBody)),
+		slog.String("outbound", truncate(out, maxLoggedBody)))
+}
+
+// redact returns a copy of the decoded JSON value with the values of the
+// fields with the given names, case-insensitive, replaced with a mask.
+func redact(v any, fields []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, val := range v {
+			if slices.ContainsFunc(fields, func(f string) bool { return strings.EqualFold(f, k) }) {
+				res[k] = "[REDACTED]"
+				continue
+			}
+			res[k] = redact(val, fields)
+		}
+		return res
+	case []any:
+		res := make([]any, len(v))
+		for i, val := range v {
+			res[i] = redact(val, fields)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// truncate cuts the string to at most n bytes, marking the cut.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…(truncated)"
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	data := map[string]any{
+		"user":   map[string]any{"name": "alice", "Password": "hunter2"},
+		"tokens": []any{map[string]any{"token": "t1"}, "plain"},
+		"token":  map[string]any{"nested": "masked as a whole"},
+	}
+
+	assert.Equal(t, map[string]any{
+		"user":   map[string]any{"name": "alice", "Password": "[REDACTED]"},
+		"tokens": []any{map[string]any{"token": "[REDACTED]"}, "plain"},
+		"token":  "[REDACTED]",
+	}, redact(data, []string{"password", "token"}))
+
+	assert.Equal(t, "hunter2", data["user"].(map[string]any)["Password"], "original is not modified")
+}
+
+func TestServer_logBodies(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	inbound := map[string]any{"user": "alice", "password": "hunter2"}
+	outbound := []byte(`{"login":"alice","secret":"s3cr3t","note":"` + strings.Repeat("x", maxLoggedBody) + `"}`)
+
+	t.Run("disabled without debug or log bodies", func(t *testing.T) {
+		buf.Reset()
+		(&Server{LogBodies: true}).logBodies(t.Context(), inbound, outbound)
+		(&Server{Debug: true}).logBodies(t.Context(), inbound, outbound)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("redacted and truncated", func(t *testing.T) {
+		buf.Reset()
+		(&Server{Debug: true, LogBodies: true}).logBodies(t.Context(), inbound, outbound)
+
+		out := buf.String()
+		assert.Contains(t, out, "webhook bodies")
+		assert.Contains(t, out, "alice")
+		assert.NotContains(t, out, "hunter2")
+		assert.NotContains(t, out, "s3cr3t")
+		assert.Contains(t, out, "(truncated)")
+		assert.Less(t, len(out), 3*maxLoggedBody)
+	})
+}
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker of a single remote host. After the threshold
+// of consecutive failures it opens and rejects requests for the cooldown,
+// then lets a single trial request through: its success closes the breaker,
+// its failure opens it again.
+type breaker struct {
+	mu       sync.Mutex
+	failures int       // consecutive failures
+	openedAt time.Time // zero if the breaker is closed
+	probing  bool      // the trial request is in flight
+}
+
+// allow reports whether the request may be sent, and if not,
+// how long is left until the trial request is allowed.
+func (b *breaker) allow(now time.Time, cooldown time.Duration) (ok bool, retryIn time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return true, 0
+	}
+	if left := b.openedAt.Add(cooldown).Sub(now); left > 0 {
+		return false, left
+	}
+	if b.probing {
+		return false, cooldown
+	}
+	b.probing = true
+	return true, 0
+}
+
+// record records the outcome of the request and reports whether
+// the breaker has tripped open or reset to closed because of it.
+func (b *breaker) record(success bool, now time.Time, threshold int) (tripped, reset bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		reset = !b.openedAt.IsZero()
+		b.failures, b.openedAt, b.probing = 0, time.Time{}, false
+		return false, reset
+	}
+
+	b.failures++
+	switch {
+	case b.probing: // trial request failed, open again
+		b.openedAt, b.probing = now, false
+		return true, false
+	case b.openedAt.IsZero() && b.failures >= threshold:
+		b.openedAt = now
+		return true, false
+	}
+	return false, false
+}
+
+// breaker returns the circuit breaker of the remote host,
+// nil if circuit breaking is disabled.
+func (s *Server) breaker(host string) *breaker {
+	if s.BreakerThreshold <= 0 {
+		return nil
+	}
+	b, _ := s.breakers.LoadOrStore(host, &breaker{})
+	return b.(*breaker)
+}
+
+// recordRemote records the outcome of the request to the remote host
+// in its circuit breaker, logging when the breaker trips or resets.
+func (s *Server) recordRemote(ctx context.Context, b *breaker, host string, success bool) {
+	if b == nil {
+		return
+	}
+	tripped, reset := b.record(success, time.Now(), s.BreakerThreshold)
+	switch {
+	case tripped:
+		n := s.breakerTrips.Add(1)
+		slog.WarnContext(ctx, "circuit breaker tripped, remote requests are rejected",
+			slog.String("host", host),
+			slog.Duration("cooldown", s.BreakerCooldown),
+			slog.Int64("breaker_trips_total", n))
+	case reset:
+		slog.InfoContext(ctx, "circuit breaker reset, remote is available", slog.String("host", host))
+	}
+}
+
+// retryAfterDuration returns the value of Retry-After header for the duration,
+// rounded up to whole seconds.
+func retryAfterDuration(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = time.Minute
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &breaker{}
+
+	t.Run("trips after threshold of consecutive failures", func(t *testing.T) {
+		tripped, _ := b.record(false, now, 2)
+		assert.False(t, tripped)
+		ok, _ := b.allow(now, cooldown)
+		assert.True(t, ok)
+
+		tripped, _ = b.record(false, now, 2)
+		assert.True(t, tripped)
+		ok, retryIn := b.allow(now.Add(time.Second), cooldown)
+		assert.False(t, ok)
+		assert.Equal(t, cooldown-time.Second, retryIn)
+	})
+
+	t.Run("failed trial request opens again", func(t *testing.T) {
+		now = now.Add(cooldown)
+		ok, _ := b.allow(now, cooldown)
+		assert.True(t, ok, "trial request is allowed after cooldown")
+		ok, _ = b.allow(now, cooldown)
+		assert.False(t, ok, "only one trial request at a time")
+
+		tripped, _ := b.record(false, now, 2)
+		assert.True(t, tripped)
+		ok, _ = b.allow(now, cooldown)
+		assert.False(t, ok)
+	})
+
+	t.Run("successful trial request resets", func(t *testing.T) {
+		now = now.Add(cooldown)
+		ok, _ := b.allow(now, cooldown)
+		require.True(t, ok)
+
+		_, reset := b.record(true, now, 2)
+		assert.True(t, reset)
+		ok, _ = b.allow(now, cooldown)
+		assert.True(t, ok)
+
+		tripped, _ := b.record(false, now, 2)
+		assert.False(t, tripped, "failures are counted from scratch")
+	})
+}
+
+func TestServer_forward_breaker(t *testing.T) {
+	var calls atomic.Int64
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer remote.Close()
+
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), BreakerThreshold: 2, BreakerCooldown: time.Minute}
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}"})
+	require.NoError(t, err)
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "circuit breaker is open")
+	assert.Equal(t, int64(2), calls.Load(), "remote must not be called while the breaker is open")
+	assert.Equal(t, int64(1), s.breakerTrips.Load())
+}
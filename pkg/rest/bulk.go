@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/cappuccinotm/slogx"
+)
+
+// bulkResult is the outcome of configuring a single webhook of the bulk,
+// either the webhook URL or the error with its code.
+type bulkResult struct {
+	WebhookURL string  `json:"webhook_url,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Code       errCode `json:"code,omitempty"`
+}
+
+// POST /configure/bulk - configures the webhooks from the JSON array of
+// configurations, each in the format of POST /configure, and returns the
+// array of their webhook URLs or errors, in the order of the request.
+// Invalid configurations are reported per item, with ?atomic=true the
+// first invalid one fails the whole request before any webhook is sealed.
+func (s *Server) handleConfigureBulk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var items []configureBody
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&items); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body: %v", err)
+		return
+	}
+
+	cfgs := make([]config.Webhook, len(items))
+	for i, item := range items {
+		cfgs[i] = item.Webhook
+		if item.Template != "" {
+			cfgs[i].Tmpl = item.Template
+		}
+	}
+
+	atomic := r.URL.Query().Get("atomic") == "true"
+	if atomic {
+		for i, cfg := range cfgs {
+			if err := s.checkWebhook(cfg); err != nil {
+				s.fail(w, r, bulkItemError(i, err))
+				return
+			}
+		}
+	}
+
+	results := make([]bulkResult, len(cfgs))
+	for i, cfg := range cfgs {
+		// checked again for the atomic bulk too, which is cheap with the templates cached
+		err := s.checkWebhook(cfg)
+		var token string
+		if err == nil {
+			token, err = s.sealWebhook(ctx, cfg)
+		}
+
+		if err == nil {
+			results[i] = bulkResult{WebhookURL: s.webhookURL(token)}
+			continue
+		}
+
+		ierr := bulkItemError(i, err)
+		if atomic {
+			s.fail(w, r, ierr)
+			return
+		}
+		results[i] = bulkResult{Error: err.Error(), Code: ierr.code}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// bulkItemError attaches the index of the failed item of the bulk to the
+// error, keeping its status and code.
+func bulkItemError(idx int, err error) *statusError {
+	serr, ok := errors.AsType[*statusError](err)
+	if !ok {
+		serr = &statusError{status: http.StatusInternalServerError, code: codeInternal, err: err}
+	}
+	return &statusError{status: serr.status, code: serr.code, err: fmt.Errorf("item %d: %w", idx, serr.err),
+		details: map[string]int{"index": idx}}
+}
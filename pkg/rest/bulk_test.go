@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_handleConfigureBulk(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: &http.Client{}, Password: "pass", Store: newFileStore(t)}
+	h := s.routes(webFS)
+
+	send := func(query, body, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/configure/bulk"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("remapjson", pass)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	stored := func() int {
+		items, err := s.Store.List(t.Context())
+		require.NoError(t, err)
+		return len(items)
+	}
+
+	const body = `[
+		{"url": "https://example.com/a", "template": "{{.msg}}", "headers": {"X-Source": "github"}},
+		{"url": "https://example.com/b", "template": "{{.msg"},
+		{"url": "ftp://example.com/c", "template": "{{.msg}}"}
+	]`
+
+	t.Run("requires basic auth", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("", body, "wrong").Code)
+		assert.Zero(t, stored())
+	})
+
+	t.Run("partial results", func(t *testing.T) {
+		rec := send("", body, "pass")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var results []bulkResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+		require.Len(t, results, 3)
+
+		require.True(t, strings.HasPrefix(results[0].WebhookURL, "http://localhost:8080/wh/"), results[0].WebhookURL)
+		cfg, err := s.Sealer.Unseal(strings.TrimPrefix(results[0].WebhookURL, "http://localhost:8080/wh/"))
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/a", cfg.URL)
+		assert.Equal(t, "{{.msg}}", cfg.Tmpl)
+		assert.Equal(t, map[string]string{"X-Source": "github"}, cfg.Headers)
+
+		assert.Empty(t, results[1].WebhookURL)
+		assert.Equal(t, codeTemplateError, results[1].Code)
+		assert.Contains(t, results[1].Error, "invalid template")
+
+		assert.Equal(t, codeInvalidConfig, results[2].Code)
+		assert.Contains(t, results[2].Error, `URL scheme "ftp" is not allowed`)
+
+		assert.Equal(t, 1, stored())
+	})
+
+	t.Run("atomic fails on the first invalid item", func(t *testing.T) {
+		rec := send("?atomic=true", body, "pass")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"error": "item 1: invalid template: parse template: template: :1: unclosed action",
+			"code": "template_error", "details": {"index": 1}}`, rec.Body.String())
+		assert.Equal(t, 1, stored(), "nothing is configured")
+	})
+
+	t.Run("atomic with valid items", func(t *testing.T) {
+		rec := send("?atomic=true", `[{"url": "https://example.com/d", "template": "{{.msg}}"}, {"template": "ok"}]`, "pass")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var results []bulkResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+		require.Len(t, results, 2)
+		for _, res := range results {
+			assert.NotEmpty(t, res.WebhookURL)
+			assert.Empty(t, res.Error)
+		}
+		assert.Equal(t, 3, stored())
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		rec := send("", `{"url": "https://example.com/a"}`, "pass")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code": "invalid_json"`)
+	})
+}
@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+)
+
+// flight is the webhook delivery shared by the identical requests.
+// The response is set once done is closed, shared is false if it
+// couldn't be remembered, e.g. because it's too large.
+type flight struct {
+	done       chan struct{}
+	resp       replay
+	remoteHost string
+	shared     bool
+}
+
+// flightWriter passes the response of the delivery through to the caller,
+// recording it for the identical requests waiting for the delivery.
+type flightWriter struct {
+	http.ResponseWriter
+	status     int
+	body       *limitedBuffer
+	remoteHost string
+}
+
+func (w *flightWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *flightWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	_, _ = w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *flightWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// coalesceKey returns the key of the request to share the delivery of
+// the identical requests under, empty if coalescing is disabled.
+// Webhooks reading the incoming headers are never coalesced, as the
+// requests with the same body may differ in them, e.g. in the tenant
+// credentials the outbound request is authenticated with.
+func (s *Server) coalesceKey(r *http.Request, cfg config.Webhook, body []byte) string {
+	if s.CoalesceWindow <= 0 || s.readsHeaders(cfg) {
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range []string{r.PathValue("token"), r.PathValue("rest"), r.Method} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readsHeaders reports whether any template of the webhook may read the
+// incoming headers. The templates are parsed and cached the same way as
+// they are rendered, so the answer is worked out once per template.
+func (s *Server) readsHeaders(cfg config.Webhook) bool {
+	tmpls := []config.Webhook{}
+	if cfg.Tmpl != "" {
+		tmpls = append(tmpls, cfg)
+	}
+	small := []string{cfg.Condition}
+	for _, values := range []map[string]string{cfg.Headers, cfg.Query} {
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			small = append(small, values[name])
+		}
+	}
+	if cfg.Auth != nil {
+		small = append(small, cfg.Auth.Token, cfg.Auth.User, cfg.Auth.Pass)
+	}
+	if cfg.OutboundSigning != nil {
+		small = append(small, cfg.OutboundSigning.Secret)
+	}
+	for _, tstr := range small {
+		if strings.Contains(tstr, cmp.Or(cfg.Delims[0], "{{")) { // others are not executed, see renderString
+			tmpls = append(tmpls, config.Webhook{URL: cfg.URL, Tmpl: tstr, Delims: cfg.Delims})
+		}
+	}
+
+	for _, tcfg := range tmpls {
+		parsed, err := s.parsed(tcfg)
+		if err != nil || parsed.readsHeaders { // invalid ones fail the request anyway
+			return true
+		}
+	}
+	return false
+}
+
+// lookupFuncs select the parts of the value passed to them by the keys,
+// which are checked on their own, so passing the whole payload to them
+// doesn't read the headers by itself.
+var lookupFuncs = []string{"index", "get", "dig", "jsonpath"}
+
+// templateReadsHeaders reports whether the parsed template, or any of the
+// associated ones, may read the incoming headers: accesses the _headers key
+// as a field, mentions it in a string, e.g. {{index . "_headers"}}, or uses
+// the whole payload with them, e.g. {{rawJSON .}}. Partials are assumed to
+// be invoked with the whole payload.
+func templateReadsHeaders(tmpl *template.Template) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeReadsHeaders(t.Tree.Root, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeReadsHeaders reports whether the node may read the incoming headers,
+// root is set if the dot is the whole payload.
+func nodeReadsHeaders(node parse.Node, root bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if nodeReadsHeaders(child, root) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return pipeReadsHeaders(n.Pipe, root)
+	case *parse.TemplateNode: // the invoked template is checked on its own
+		return pipeReadsHeaders(n.Pipe, root)
+	case *parse.IfNode:
+		return pipeReadsHeaders(n.Pipe, root) || nodeReadsHeaders(n.List, root) || nodeReadsHeaders(n.ElseList, root)
+	case *parse.WithNode:
+		return pipeReadsHeaders(n.Pipe, root) || nodeReadsHeaders(n.List, false) || nodeReadsHeaders(n.ElseList, root)
+	case *parse.RangeNode:
+		return pipeReadsHeaders(n.Pipe, root) || nodeReadsHeaders(n.List, false) || nodeReadsHeaders(n.ElseList, root)
+	}
+	return false
+}
+
+// pipeReadsHeaders reports whether the pipeline may read the incoming headers.
+func pipeReadsHeaders(p *parse.PipeNode, root bool) bool {
+	if p == nil {
+		return false
+	}
+	for _, cmd := range p.Cmds {
+		lookup := false
+		if len(cmd.Args) > 1 {
+			if fn, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+				lookup = slices.Contains(lookupFuncs, fn.Ident)
+			}
+		}
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				if slices.Contains(a.Ident, headersKey) {
+					return true
+				}
+			case *parse.VariableNode:
+				if slices.Contains(a.Ident, headersKey) || (len(a.Ident) == 1 && a.Ident[0] == "$" && !lookup) {
+					return true
+				}
+			case *parse.DotNode:
+				if root && !lookup {
+					return true
+				}
+			case *parse.StringNode:
+				if strings.Contains(a.Text, headersKey) {
+					return true
+				}
+			case *parse.ChainNode:
+				if slices.Contains(a.Field, headersKey) {
+					return true
+				}
+				if inner, ok := a.Node.(*parse.PipeNode); ok && pipeReadsHeaders(inner, root) {
+					return true
+				}
+			case *parse.PipeNode:
+				if pipeReadsHeaders(a, root) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// coalesce makes the delivery of the request, unless the identical one is
+// already in flight, or finished within the coalescing window, in which case
+// the caller is answered with its response instead.
+func (s *Server) coalesce(w http.ResponseWriter, r *http.Request, key string, deliver func(http.ResponseWriter)) {
+	s.flightsMu.Lock()
+	if f, ok := s.flights[key]; ok {
+		s.flightsMu.Unlock()
+		select {
+		case <-f.done:
+		case <-r.Context().Done():
+			return // the caller is gone
+		}
+		if f.shared {
+			slog.InfoContext(r.Context(), "answering with the response of the identical request")
+			setRemoteHost(w, f.remoteHost)
+			f.resp.write(w, "X-Coalesced")
+			return
+		}
+		deliver(w)
+		return
+	}
+
+	if s.flights == nil {
+		s.flights = map[string]*flight{}
+	}
+	f := &flight{done: make(chan struct{})}
+	s.flights[key] = f
+	s.flightsMu.Unlock()
+
+	fw := &flightWriter{ResponseWriter: w, body: &limitedBuffer{limit: s.maxBodySize()}}
+	defer func() {
+		f.resp = replay{status: fw.status, header: w.Header().Clone(), body: fw.body.Bytes()}
+		f.remoteHost, f.shared = fw.remoteHost, fw.status != 0 && !fw.body.overflow
+		close(f.done)
+		time.AfterFunc(s.CoalesceWindow, func() {
+			s.flightsMu.Lock()
+			defer s.flightsMu.Unlock()
+			if s.flights[key] == f {
+				delete(s.flights, key)
+			}
+		})
+	}()
+
+	deliver(fw)
+}
@@ -0,0 +1,174 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_coalesce(t *testing.T) {
+	var calls atomic.Int64
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond) // keep the delivery in flight for the identical requests
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Remote", "yes")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write(b)
+	}))
+	defer remote.Close()
+
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), CoalesceWindow: time.Minute, DeliveryTTL: time.Minute}
+	h := s.routes(webFS)
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v": "{{.value}}"}`})
+	require.NoError(t, err)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/wh/"+token, strings.NewReader(body)))
+		return rec
+	}
+
+	const n = 20
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() { recs[i] = send(`{"value":"x"}`) })
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), calls.Load(), "identical requests share a single delivery")
+	coalesced, ids := 0, map[string]bool{}
+	for _, rec := range recs {
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.JSONEq(t, `{"v": "x"}`, rec.Body.String())
+		assert.Equal(t, "yes", rec.Header().Get("X-Remote"))
+		if rec.Header().Get("X-Coalesced") == "true" {
+			coalesced++
+		}
+		ids[rec.Header().Get("X-Delivery-ID")] = true
+	}
+	assert.Equal(t, n-1, coalesced)
+	assert.Len(t, ids, n, "each request keeps its own delivery ID")
+
+	t.Run("different body is delivered", func(t *testing.T) {
+		rec := send(`{"value":"y"}`)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.JSONEq(t, `{"v": "y"}`, rec.Body.String())
+		assert.Empty(t, rec.Header().Get("X-Coalesced"))
+		assert.Equal(t, int64(2), calls.Load())
+	})
+
+	t.Run("identical request within the window", func(t *testing.T) {
+		rec := send(`{"value":"x"}`)
+		assert.Equal(t, "true", rec.Header().Get("X-Coalesced"))
+		assert.Equal(t, int64(2), calls.Load())
+	})
+}
+
+func TestServer_coalesce_window(t *testing.T) {
+	var calls atomic.Int64
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls.Add(1) }))
+	defer remote.Close()
+
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), CoalesceWindow: 10 * time.Millisecond}
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`})
+	require.NoError(t, err)
+
+	s.handleWebhook(httptest.NewRecorder(), webhookRequest(http.MethodPost, token, `{"value":"x"}`))
+	require.Eventually(t, func() bool {
+		s.flightsMu.Lock()
+		defer s.flightsMu.Unlock()
+		return len(s.flights) == 0
+	}, time.Second, 5*time.Millisecond, "flight is forgotten after the window")
+
+	rec := httptest.NewRecorder()
+	s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"x"}`))
+	assert.Empty(t, rec.Header().Get("X-Coalesced"))
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestServer_coalesce_headers(t *testing.T) {
+	var calls atomic.Int64
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond) // keep the delivery in flight for the identical request
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer remote.Close()
+
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), CoalesceWindow: time.Minute}
+	h := s.routes(webFS)
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`,
+		Auth: &config.Auth{Type: config.AuthTypeBearer, Token: `{{._headers.Get "X-Tenant-Token"}}`}})
+	require.NoError(t, err)
+
+	send := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/wh/"+token, strings.NewReader(`{"value":"x"}`))
+		req.Header.Set("X-Tenant-Token", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var a, b *httptest.ResponseRecorder
+	var wg sync.WaitGroup
+	wg.Go(func() { a = send("tenant-a") })
+	wg.Go(func() { b = send("tenant-b") })
+	wg.Wait()
+
+	assert.Equal(t, int64(2), calls.Load(), "each tenant is delivered with its own credentials")
+	assert.Equal(t, "Bearer tenant-a", a.Body.String())
+	assert.Equal(t, "Bearer tenant-b", b.Body.String())
+	assert.Empty(t, a.Header().Get("X-Coalesced"))
+	assert.Empty(t, b.Header().Get("X-Coalesced"))
+}
+
+func TestServer_readsHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Webhook
+		want bool
+	}{
+		{name: "no templates", cfg: config.Webhook{URL: "http://example.com"}, want: false},
+		{name: "fields", cfg: config.Webhook{Tmpl: `{"id": {{.id}}, "n": {{.order.customer.name}}}`}, want: false},
+		{name: "literal text", cfg: config.Webhook{Tmpl: `no _headers here, {{.id}}`}, want: false},
+		{name: "lookups", cfg: config.Webhook{Tmpl: `{{index . "id"}} {{get . "a"}} {{jsonpath . "$.a"}} {{dig $ "a" "b"}}`}, want: false},
+		{name: "range over a field", cfg: config.Webhook{Tmpl: `{{range .items}}{{rawJSON .}}{{end}}`}, want: false},
+		{name: "headers field", cfg: config.Webhook{Tmpl: `{{._headers.Get "X-Tenant"}}`}, want: true},
+		{name: "headers of variable", cfg: config.Webhook{Tmpl: `{{with .id}}{{$._headers}}{{end}}`}, want: true},
+		{name: "headers by index", cfg: config.Webhook{Tmpl: `{{index . "_headers"}}`}, want: true},
+		{name: "whole payload", cfg: config.Webhook{Tmpl: `{{rawJSON .}}`}, want: true},
+		{name: "whole payload by variable", cfg: config.Webhook{Tmpl: `{{with .id}}{{rawJSON $}}{{end}}`}, want: true},
+		{name: "whole payload in pipeline", cfg: config.Webhook{Tmpl: `{{. | rawJSON}}`}, want: true},
+		{name: "headers in condition", cfg: config.Webhook{Tmpl: `{{.id}}`, Condition: `{{eq (._headers.Get "X-Env") "prod"}}`}, want: true},
+		{name: "headers in header", cfg: config.Webhook{Tmpl: `{{.id}}`, Headers: map[string]string{"X-Tenant": `{{._headers.Get "X-Tenant"}}`}}, want: true},
+		{name: "static header", cfg: config.Webhook{Tmpl: `{{.id}}`, Headers: map[string]string{"X-Tenant": "_headers"}}, want: false},
+		{name: "headers in auth", cfg: config.Webhook{Tmpl: `{{.id}}`, Auth: &config.Auth{Token: `{{._headers.Get "X-Token"}}`}}, want: true},
+		{name: "custom delims", cfg: config.Webhook{Tmpl: `[[._headers]]`, Delims: [2]string{"[[", "]]"}}, want: true},
+		{name: "invalid template", cfg: config.Webhook{Tmpl: `{{.id`}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{}
+			assert.Equal(t, tt.want, srv.readsHeaders(tt.cfg))
+			assert.Equal(t, tt.want, srv.readsHeaders(tt.cfg), "cached")
+		})
+	}
+}
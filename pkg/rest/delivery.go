@@ -0,0 +1,303 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/cappuccinotm/slogx"
+	"github.com/go-pkgz/expirable-cache/v3"
+	"github.com/google/uuid"
+)
+
+// maxReceipts is the maximum number of remembered delivery receipts,
+// the least recently used ones are evicted first.
+const maxReceipts = 100000
+
+// delivery statuses of the receipt.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// receipt is the outcome of a webhook delivery. It must never expose
+// the decrypted configuration or the payload, only the token fingerprint.
+type receipt struct {
+	ID         string    `json:"id"`
+	Token      string    `json:"token"`
+	Status     string    `json:"status"`
+	Response   int       `json:"response_status,omitempty"`
+	RemoteHost string    `json:"remote_host,omitempty"`
+	Attempts   int       `json:"attempts"`
+	Replayable bool      `json:"replayable"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	request []byte // encoded inboundRequest, encrypted if configured, nil if not kept
+}
+
+// inboundRequest is the incoming webhook request, kept with the receipt
+// to replay the delivery.
+type inboundRequest struct {
+	Token  string      `json:"token"`
+	Method string      `json:"method"`
+	Path   string      `json:"path,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body"`
+}
+
+// replayHeaders are the inbound headers kept to replay the delivery,
+// the ones required to decode the body.
+var replayHeaders = []string{"Content-Type", "Content-Encoding"}
+
+// replayedKey marks the context of the replayed webhook requests.
+type replayedKey struct{}
+
+// replayed reports whether the webhook request is a replay of the delivery.
+func replayed(ctx context.Context) bool {
+	v, _ := ctx.Value(replayedKey{}).(bool)
+	return v
+}
+
+// receiptWriter captures the response status and the remote host
+// of the webhook delivery for the receipt.
+type receiptWriter struct {
+	http.ResponseWriter
+	status     int
+	remoteHost string
+}
+
+func (w *receiptWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *receiptWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *receiptWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// deliveryReceipts wraps the webhook handler to record the outcome of each
+// delivery under a receipt ID, returned in the X-Delivery-ID header, if
+// enabled. Retries, which pass the X-Delivery-ID of the same webhook back,
+// are recorded as further attempts of the same delivery.
+func (s *Server) deliveryReceipts(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.DeliveryTTL <= 0 {
+			next(w, r)
+			return
+		}
+
+		now := time.Now().UTC()
+		token := config.Fingerprint(r.PathValue("token"))
+
+		// retries of the delivery may run concurrently, count each of them
+		s.receiptsMu.Lock()
+		rc, ok := s.receiptCache().Get(r.Header.Get("X-Delivery-ID"))
+		if !ok || rc.Token != token {
+			rc = receipt{ID: uuid.NewString(), Token: token, CreatedAt: now}
+		}
+		rc.Attempts++
+		rc.Status, rc.Response, rc.UpdatedAt = deliveryPending, 0, now
+		s.receiptCache().Add(rc.ID, rc)
+		s.receiptsMu.Unlock()
+
+		if s.ReplayBodySize > 0 && !replayed(r.Context()) {
+			request := s.keepRequest(r, rc.ID)
+			rc = s.updateReceipt(rc, func(rc *receipt) {
+				rc.request, rc.Replayable = request, request != nil
+			})
+		}
+
+		w.Header().Set("X-Delivery-ID", rc.ID)
+		rw := &receiptWriter{ResponseWriter: w}
+		next(rw, r)
+
+		s.updateReceipt(rc, func(rc *receipt) {
+			rc.Response = rw.status
+			if rc.Response == 0 {
+				rc.Response = http.StatusOK
+			}
+			rc.Status = deliveryDelivered
+			if rc.Response >= http.StatusBadRequest {
+				rc.Status = deliveryFailed
+			}
+			rc.RemoteHost = rw.remoteHost
+			rc.UpdatedAt = time.Now().UTC()
+		})
+	}
+}
+
+// updateReceipt applies the update to the current version of the receipt,
+// or to rc itself if it's evicted meanwhile, and stores it. The updates
+// are serialized, so the ones of the concurrent retries are not lost.
+func (s *Server) updateReceipt(rc receipt, update func(rc *receipt)) receipt {
+	s.receiptsMu.Lock()
+	defer s.receiptsMu.Unlock()
+
+	if cur, ok := s.receiptCache().Get(rc.ID); ok {
+		rc = cur
+	}
+	update(&rc)
+	s.receiptCache().Add(rc.ID, rc)
+	return rc
+}
+
+// GET /deliveries/{id} - returns the receipt of the webhook delivery.
+func (s *Server) handleDelivery(w http.ResponseWriter, r *http.Request) {
+	rc, ok := s.receiptCache().Get(r.PathValue("id"))
+	if !ok {
+		s.error(w, r, http.StatusNotFound, codeNotFound, "delivery not found or expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rc); err != nil {
+		slog.WarnContext(r.Context(), "failed to write response", slogx.Error(err))
+	}
+}
+
+// POST /deliveries/{id}/replay - sends the kept incoming request of the
+// delivery to the webhook again, recording it as the next attempt.
+// Signatures of the replayed requests are not verified, as the original
+// ones have been already, and their timestamps are likely to be expired.
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	rc, ok := s.receiptCache().Get(r.PathValue("id"))
+	if !ok {
+		s.error(w, r, http.StatusNotFound, codeNotFound, "delivery not found or expired")
+		return
+	}
+	if rc.request == nil {
+		s.error(w, r, http.StatusConflict, codeInvalidRequest, "request of the delivery is not kept, it can't be replayed")
+		return
+	}
+
+	in, err := s.openRequest(rc.request, rc.ID)
+	if err != nil {
+		s.error(w, r, http.StatusInternalServerError, codeInternal, "failed to read the kept request: %v", err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), replayedKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, in.Method, "/wh/"+in.Token+"/"+in.Path, bytes.NewReader(in.Body))
+	if err != nil {
+		s.error(w, r, http.StatusInternalServerError, codeInternal, "failed to rebuild the request: %v", err)
+		return
+	}
+	req.Header = in.Header
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("X-Delivery-ID", rc.ID)
+	req.RemoteAddr = r.RemoteAddr
+	req.SetPathValue("token", in.Token)
+	req.SetPathValue("rest", in.Path)
+
+	slog.InfoContext(ctx, "replaying delivery", slog.String("id", rc.ID), slog.String("token", rc.Token))
+	s.deliveryReceipts(s.accessLog(s.handleWebhook))(w, req)
+}
+
+// keepRequest encodes the incoming request to be replayed later, encrypting
+// it if configured. Returns nil if the body is larger than the limit.
+// The body of the request is restored to be read by the handler.
+func (s *Server) keepRequest(r *http.Request, id string) []byte {
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.ReplayBodySize+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || int64(len(body)) > s.ReplayBodySize {
+		return nil
+	}
+
+	in := inboundRequest{Token: r.PathValue("token"), Method: r.Method, Path: r.PathValue("rest"), Body: body}
+	for _, name := range replayHeaders {
+		if v := r.Header.Get(name); v != "" {
+			if in.Header == nil {
+				in.Header = http.Header{}
+			}
+			in.Header.Set(name, v)
+		}
+	}
+
+	b, err := json.Marshal(in)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to encode request to replay", slogx.Error(err))
+		return nil
+	}
+
+	if !s.EncryptReplayBodies {
+		return b
+	}
+
+	gcm, err := s.replayCipher()
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to encrypt request to replay", slogx.Error(err))
+		return nil
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, _ = rand.Read(nonce) // never fails, see crypto/rand.Read
+	return gcm.Seal(nonce, nonce, b, []byte(id))
+}
+
+// openRequest decodes the request kept by keepRequest.
+func (s *Server) openRequest(b []byte, id string) (inboundRequest, error) {
+	if s.EncryptReplayBodies {
+		gcm, err := s.replayCipher()
+		if err != nil {
+			return inboundRequest{}, err
+		}
+		if len(b) < gcm.NonceSize() {
+			return inboundRequest{}, errors.New("encrypted request is too short")
+		}
+		if b, err = gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(id)); err != nil {
+			return inboundRequest{}, fmt.Errorf("decrypt: %w", err)
+		}
+	}
+
+	var in inboundRequest
+	if err := json.Unmarshal(b, &in); err != nil {
+		return inboundRequest{}, fmt.Errorf("decode: %w", err)
+	}
+	return in, nil
+}
+
+// replayCipher returns the AES-256-GCM cipher to encrypt the kept requests
+// with, its key is random and lives only in memory of the process.
+func (s *Server) replayCipher() (cipher.AEAD, error) {
+	s.replayCipherOnce.Do(func() {
+		key := make([]byte, 32)
+		_, _ = rand.Read(key) // never fails, see crypto/rand.Read
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			s.replayCipherErr = fmt.Errorf("create cipher: %w", err)
+			return
+		}
+		s.replayAEAD, s.replayCipherErr = cipher.NewGCM(block)
+	})
+	return s.replayAEAD, s.replayCipherErr
+}
+
+func (s *Server) receiptCache() cache.Cache[string, receipt] {
+	s.receiptsOnce.Do(func() {
+		s.receipts = cache.NewCache[string, receipt]().WithLRU().WithMaxKeys(maxReceipts).WithTTL(s.DeliveryTTL)
+	})
+	return s.receipts
+}
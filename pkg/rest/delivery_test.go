@@ -0,0 +1,206 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_deliveryReceipts(t *testing.T) {
+	status := http.StatusBadGateway
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer remote.Close()
+
+	remoteURL, err := url.Parse(remote.URL)
+	require.NoError(t, err)
+
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), DeliveryTTL: time.Minute, AccessLog: &bytes.Buffer{}}
+	h := s.routes(webFS)
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v": "{{.value}}"}`})
+	require.NoError(t, err)
+
+	deliver := func(id string) string {
+		req := httptest.NewRequest(http.MethodPost, "/wh/"+token, strings.NewReader(`{"value":"x"}`))
+		if id != "" {
+			req.Header.Set("X-Delivery-ID", id)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, status, rec.Code)
+		return rec.Header().Get("X-Delivery-ID")
+	}
+
+	query := func(id string) (rc receipt, code int) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deliveries/"+id, http.NoBody))
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rc))
+		}
+		return rc, rec.Code
+	}
+
+	id := deliver("")
+	require.NotEmpty(t, id)
+
+	rc, code := query(id)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, id, rc.ID)
+	assert.Equal(t, config.Fingerprint(token), rc.Token)
+	assert.Equal(t, deliveryFailed, rc.Status)
+	assert.Equal(t, http.StatusBadGateway, rc.Response)
+	assert.Equal(t, remoteURL.Host, rc.RemoteHost)
+	assert.Equal(t, 1, rc.Attempts)
+	assert.False(t, rc.CreatedAt.IsZero())
+
+	t.Run("retry continues the delivery", func(t *testing.T) {
+		status = http.StatusOK
+		assert.Equal(t, id, deliver(id))
+
+		rc, code := query(id)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, deliveryDelivered, rc.Status)
+		assert.Equal(t, http.StatusOK, rc.Response)
+		assert.Equal(t, 2, rc.Attempts)
+		assert.False(t, rc.UpdatedAt.Before(rc.CreatedAt))
+	})
+
+	t.Run("concurrent retries are all counted", func(t *testing.T) {
+		const retries = 20
+		var wg sync.WaitGroup
+		for range retries {
+			wg.Go(func() {
+				req := httptest.NewRequest(http.MethodPost, "/wh/"+token, strings.NewReader(`{"value":"x"}`))
+				req.Header.Set("X-Delivery-ID", id)
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			})
+		}
+		wg.Wait()
+
+		rc, code := query(id)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2+retries, rc.Attempts)
+		assert.Equal(t, deliveryDelivered, rc.Status)
+	})
+
+	t.Run("unknown delivery ID starts a new delivery", func(t *testing.T) {
+		newID := deliver("unknown")
+		assert.NotEqual(t, "unknown", newID)
+		assert.NotEqual(t, id, newID)
+	})
+
+	t.Run("unknown delivery is not found", func(t *testing.T) {
+		_, code := query("unknown")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+func TestServer_deliveryReceipts_disabled(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}}
+	h := s.routes(webFS)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/wh/invalid", strings.NewReader(`{}`)))
+	assert.Empty(t, rec.Header().Get("X-Delivery-ID"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deliveries/some-id", http.NoBody))
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+}
+
+func TestServer_handleReplay(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%t", encrypt), func(t *testing.T) {
+			status := http.StatusBadGateway
+			var bodies []string
+			remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(b))
+				w.WriteHeader(status)
+			}))
+			defer remote.Close()
+
+			s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+				Client: remote.Client(), DeliveryTTL: time.Minute, Password: "pass", ReplayBodySize: 32, EncryptReplayBodies: encrypt}
+			h := s.routes(webFS)
+
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v": "{{.value}}", "path": "{{index ._path 0}}"}`})
+			require.NoError(t, err)
+
+			deliver := func(body string) string {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/wh/"+token+"/orders", strings.NewReader(body)))
+				require.Equal(t, status, rec.Code)
+				return rec.Header().Get("X-Delivery-ID")
+			}
+
+			replay := func(id, pass string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/deliveries/"+id+"/replay", http.NoBody)
+				req.SetBasicAuth("remapjson", pass)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+
+			id := deliver(`{"value":"x"}`)
+
+			t.Run("requires basic auth", func(t *testing.T) {
+				assert.Equal(t, http.StatusUnauthorized, replay(id, "wrong").Code)
+			})
+
+			t.Run("sends the kept request again", func(t *testing.T) {
+				status = http.StatusOK
+				rec := replay(id, "pass")
+				require.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, id, rec.Header().Get("X-Delivery-ID"))
+				require.Len(t, bodies, 2)
+				assert.JSONEq(t, `{"v": "x", "path": "orders"}`, bodies[1])
+
+				rc, ok := s.receiptCache().Get(id)
+				require.True(t, ok)
+				assert.Equal(t, deliveryDelivered, rc.Status)
+				assert.Equal(t, 2, rc.Attempts)
+				assert.True(t, rc.Replayable)
+				if encrypt {
+					assert.NotContains(t, string(rc.request), `"value"`)
+				}
+			})
+
+			t.Run("body over the limit is not kept", func(t *testing.T) {
+				large := deliver(`{"value":"` + strings.Repeat("x", 32) + `"}`)
+				rec := replay(large, "pass")
+				assert.Equal(t, http.StatusConflict, rec.Code)
+				assert.Contains(t, rec.Body.String(), "can't be replayed")
+			})
+
+			t.Run("unknown delivery", func(t *testing.T) {
+				assert.Equal(t, http.StatusNotFound, replay("unknown", "pass").Code)
+			})
+		})
+	}
+}
+
+func TestServer_handleReplay_disabled(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		DeliveryTTL: time.Minute, ReplayBodySize: 1024}
+	h := s.routes(webFS)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/deliveries/some-id/replay", http.NoBody))
+	assert.NotEqual(t, http.StatusOK, rec.Code, "replays require the password")
+}
@@ -0,0 +1,301 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/google/uuid"
+)
+
+// funcMap returns the functions available in webhook templates.
+// The same set is used in /render preview, so what is previewed matches
+// what is forwarded, except for nondeterministic functions: now, nowUnix
+// and uuidv4 produce a different value on every execution, and
+// requestNonce on every request.
+func (s *Server) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"env":        s.env,
+		"now":        time.Now,
+		"nowUnix":    func() int64 { return time.Now().Unix() },
+		"unixEpoch":  func(t time.Time) int64 { return t.Unix() },
+		"hmacSHA256": hmacSHA256,
+		"sha256": func(v any) string {
+			h := sha256.Sum256([]byte(str(v)))
+			return hex.EncodeToString(h[:])
+		},
+		"base64":     func(v any) string { return base64.StdEncoding.EncodeToString([]byte(str(v))) },
+		"b64dec":     b64dec,
+		"uuidv4":     uuid.NewString,
+		"jsonEscape": jsonEscape,
+		"rawJSON":    rawJSON,
+		"jsonpath":   jsonpath,
+		"toYAML":     toYAML,
+		"fromYAML":   s.fromYAML,
+		"default":    dflt,
+		"coalesce":   coalesce,
+		"get":        get,
+		"dig":        dig,
+		"urlencode":  urlencode,
+		nonceFunc:    func() (string, error) { return "", errNoNonce },
+	}
+}
+
+// nonceFunc is the name of the function returning the nonce of the request,
+// the same in all templates of the webhook rendered for the request.
+const nonceFunc = "requestNonce"
+
+var errNoNonce = errors.New("nonce is not bound to the template")
+
+// newNonce returns a random nonce for the request, hex-encoded.
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // never fails, see crypto/rand.Read
+	return hex.EncodeToString(b)
+}
+
+// bindRequest returns the template with the functions bound to the request:
+// requestNonce returning its nonce and, if the output is disclosed to the
+// caller, env failing. Parsed templates are cached and shared between
+// requests, so the ones calling them are cloned, and others are returned as is.
+func bindRequest(tmpl *template.Template, cfg config.Webhook, in inbound) (*template.Template, error) {
+	funcs := template.FuncMap{}
+	if calls(cfg, nonceFunc) {
+		funcs[nonceFunc] = func() string { return in.nonce }
+	}
+	if in.disclosed && calls(cfg, "env") {
+		funcs["env"] = func(string) (string, error) { return "", errEnvDisclosed }
+	}
+	if len(funcs) == 0 {
+		return tmpl, nil
+	}
+
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return clone.Funcs(funcs), nil
+}
+
+// calls reports whether the template of the webhook or any of its partials
+// may call the function. It doesn't parse them, so it may report false
+// positives, e.g. for the name in a string literal.
+func calls(cfg config.Webhook, name string) bool {
+	if strings.Contains(cfg.Tmpl, name) {
+		return true
+	}
+	for _, partial := range cfg.Partials {
+		if strings.Contains(partial, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// errEnvDisclosed is returned by env, when the rendered output is shown to
+// the caller: in /render and previews, and in responses of local webhooks.
+// The allowed variables are meant to be hidden from the token authors too.
+var errEnvDisclosed = errors.New("environment variables are not available when the output is shown to the caller")
+
+// secondPassFuncs override the functions in the second pass of the double
+// pass rendering. Its template code may come from the payload, and local
+// webhooks respond with the output, so it could read the allowed variables.
+var secondPassFuncs = template.FuncMap{
+	"env":     func(string) (string, error) { return "", errSecondPass },
+	nonceFunc: func() (string, error) { return "", errSecondPass },
+}
+
+var errSecondPass = errors.New("not available in the second pass")
+
+// env returns the value of the environment variable, if it is allowed.
+// Tokens are configured by anyone with access to the web UI, so reading
+// arbitrary variables would allow to exfiltrate the server secrets.
+func (s *Server) env(name string) (string, error) {
+	if !slices.Contains(s.TemplateEnv, name) {
+		return "", fmt.Errorf("environment variable %q is not allowed", name)
+	}
+	return os.Getenv(name), nil
+}
+
+// urlencode encodes the object as form values, e.g. a=1&b=2, and escapes
+// other values to be placed into the query string or the form body.
+func urlencode(v any) (string, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return url.QueryEscape(str(v)), nil
+	}
+	values, err := formValues(m)
+	if err != nil {
+		return "", err
+	}
+	return values.Encode(), nil
+}
+
+// hmacSHA256 returns hex-encoded HMAC-SHA256 of the message with the given key.
+func hmacSHA256(key, msg any) string {
+	mac := hmac.New(sha256.New, []byte(str(key)))
+	_, _ = mac.Write([]byte(str(msg)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// jsonEscape escapes the value to be embedded into a JSON string literal,
+// without the surrounding quotes.
+func jsonEscape(v any) string {
+	b, _ := json.Marshal(str(v)) // strings are always encodable
+	return string(b[1 : len(b)-1])
+}
+
+// b64dec decodes the standard base64 encoded value, padded or not.
+func b64dec(v any) (string, error) {
+	b, err := decodeBase64(str(v))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// decodeBase64 decodes the standard base64, padded or not,
+// ignoring the surrounding whitespace.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "=") {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// rawJSON emits the value verbatim if it is valid JSON, e.g. the raw body,
+// and as a JSON string otherwise, so that the result is always valid JSON.
+func rawJSON(v any) string {
+	s := str(v)
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, []byte(s)); err == nil {
+		return buf.String()
+	}
+	b, _ := json.Marshal(s) // strings are always encodable
+	return string(b)
+}
+
+// empty reports whether the value is missing, zero, or an empty
+// string or collection, i.e. the one to fall back from.
+func empty(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	default:
+		return rv.IsZero()
+	}
+}
+
+// dflt returns the value, or the fallback if the value is empty,
+// e.g. {{.name | default "anonymous"}}.
+func dflt(fallback, v any) any {
+	if empty(v) {
+		return fallback
+	}
+	return v
+}
+
+// coalesce returns the first non-empty value, nil if all are empty.
+func coalesce(vs ...any) any {
+	for _, v := range vs {
+		if !empty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// get returns the field of the object or the element of the array,
+// empty string if there is none, e.g. {{get .headers "X-Request-ID"}}.
+func get(v, key any) any {
+	if val, ok := member(v, key); ok {
+		return val
+	}
+	return ""
+}
+
+// dig walks the nested objects and arrays by the keys, returning the
+// fallback if any of them is missing, or the found value is nil,
+// e.g. {{dig "user" "email" "unknown" .}}.
+func dig(args ...any) (any, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("dig expects keys, the fallback and the value, got %d arguments", len(args))
+	}
+	keys, fallback, v := args[:len(args)-2], args[len(args)-2], args[len(args)-1]
+	for _, key := range keys {
+		var ok bool
+		if v, ok = member(v, key); !ok {
+			return fallback, nil
+		}
+	}
+	if v == nil {
+		return fallback, nil
+	}
+	return v, nil
+}
+
+// member returns the field of the decoded JSON object by the name, or
+// the element of the decoded JSON array by the index, negative indices
+// count from the end.
+func member(v, key any) (any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		val, ok := v[str(key)]
+		return val, ok
+	case []any:
+		var i int
+		switch key := key.(type) {
+		case int:
+			i = key
+		case float64:
+			i = int(key)
+		default:
+			n, err := strconv.Atoi(str(key))
+			if err != nil {
+				return nil, false
+			}
+			i = n
+		}
+		if i < 0 {
+			i += len(v)
+		}
+		if i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return v[i], true
+	default:
+		return nil, false
+	}
+}
+
+// str converts template argument to string, taking strings and byte slices as is.
+func str(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuncMap(t *testing.T) {
+	s := &Server{}
+	exec := func(t *testing.T, tmpl string, data any) string {
+		t.Helper()
+		tt, err := template.New("").Funcs(s.funcMap()).Parse(tmpl)
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		require.NoError(t, tt.Execute(buf, data))
+		return buf.String()
+	}
+
+	t.Run("deterministic functions", func(t *testing.T) {
+		data := map[string]any{"secret": "key", "body": "The quick brown fox jumps over the lazy dog"}
+		tests := []struct {
+			name string
+			tmpl string
+			want string
+		}{
+			{
+				name: "hmacSHA256",
+				tmpl: `{{hmacSHA256 .secret .body}}`,
+				want: "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+			},
+			{
+				name: "sha256",
+				tmpl: `{{sha256 .body}}`,
+				want: "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592",
+			},
+			{name: "base64", tmpl: `{{base64 .secret}}`, want: "a2V5"},
+			{name: "jsonEscape", tmpl: `{"v": "{{jsonEscape "a \"quoted\"\nline"}}"}`, want: `{"v": "a \"quoted\"\nline"}`},
+			{name: "rawJSON object", tmpl: `{{rawJSON "{\"a\": [1, 2]}"}}`, want: `{"a":[1,2]}`},
+			{name: "rawJSON non-JSON", tmpl: `{{rawJSON "not \"json\""}}`, want: `"not \"json\""`},
+			{name: "b64dec", tmpl: `{{b64dec "a2V5"}}`, want: "key"},
+			{name: "b64dec unpadded", tmpl: `{{b64dec "aGk"}}`, want: "hi"},
+			{name: "non-string argument", tmpl: `{{sha256 1}}`, want: "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, exec(t, tt.tmpl, data))
+			})
+		}
+	})
+
+	t.Run("urlencode", func(t *testing.T) {
+		data := map[string]any{"fields": map[string]any{"b": "x y", "a": 1.0, "tags": []any{"ci", "cd"}}, "q": "a&b=c"}
+		assert.Equal(t, "a=1&b=x+y&tags=ci&tags=cd", exec(t, `{{urlencode .fields}}`, data))
+		assert.Equal(t, "a%26b%3Dc", exec(t, `{{urlencode .q}}`, data))
+
+		tt, err := template.New("").Funcs(s.funcMap()).Parse(`{{urlencode .}}`)
+		require.NoError(t, err)
+		err = tt.Execute(&bytes.Buffer{}, map[string]any{"user": map[string]any{"id": 1}})
+		require.ErrorContains(t, err, `field "user": nested objects and arrays can't be form-encoded`)
+	})
+
+	t.Run("time", func(t *testing.T) {
+		before := time.Now().Unix()
+		for _, tmpl := range []string{`{{nowUnix}}`, `{{now | unixEpoch}}`} {
+			ts, err := strconv.ParseInt(exec(t, tmpl, nil), 10, 64)
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, ts, before)
+			assert.LessOrEqual(t, ts, time.Now().Unix())
+		}
+	})
+
+	t.Run("jsonpath", func(t *testing.T) {
+		data := map[string]any{"items": []any{
+			map[string]any{"id": "a", "price": 5.0},
+			map[string]any{"id": "b", "price": 15.0},
+		}}
+		assert.Equal(t, "a", exec(t, `{{jsonpath . "$.items[0].id"}}`, data))
+		assert.Equal(t, "b;", exec(t, `{{range jsonpath . "$.items[?(@.price > 10)]"}}{{.id}};{{end}}`, data))
+
+		tt, err := template.New("").Funcs(s.funcMap()).Parse(`{{jsonpath . "items[0]"}}`)
+		require.NoError(t, err)
+		err = tt.Execute(&bytes.Buffer{}, data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid JSONPath")
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		data := map[string]any{"doc": "name: alice\ntags: [a, b]\n"}
+		assert.Equal(t, "alice b", exec(t, `{{with fromYAML .doc}}{{.name}} {{index .tags 1}}{{end}}`, data))
+		assert.Equal(t, "name: alice\ntags:\n  - a\n  - b\n", exec(t, `{{toYAML (fromYAML .doc)}}`, data))
+
+		tt, err := template.New("").Funcs((&Server{MaxJSONDepth: 2}).funcMap()).Parse(`{{fromYAML .doc}}`)
+		require.NoError(t, err)
+		err = tt.Execute(&bytes.Buffer{}, map[string]any{"doc": "a: {b: [1]}"})
+		assert.ErrorContains(t, err, "nesting depth exceeds 2")
+	})
+
+	t.Run("fallbacks and safe access", func(t *testing.T) {
+		data := map[string]any{
+			"user":  map[string]any{"name": "alice", "email": "", "roles": []any{"admin", "dev"}},
+			"count": 0.0,
+			"tags":  []any{},
+			"nick":  "al",
+		}
+		tests := []struct {
+			name string
+			tmpl string
+			want string
+		}{
+			{name: "default on missing", tmpl: `{{.missing | default "none"}}`, want: "none"},
+			{name: "default on empty string", tmpl: `{{.user.email | default "none"}}`, want: "none"},
+			{name: "default on zero", tmpl: `{{default 42 .count}}`, want: "42"},
+			{name: "default on empty list", tmpl: `{{default "none" .tags}}`, want: "none"},
+			{name: "default on value", tmpl: `{{.user.name | default "none"}}`, want: "alice"},
+			{name: "coalesce", tmpl: `{{coalesce .missing .user.email .nick .user.name}}`, want: "al"},
+			{name: "coalesce all empty", tmpl: `{{coalesce .missing .count | default "none"}}`, want: "none"},
+			{name: "get field", tmpl: `{{get .user "name"}}`, want: "alice"},
+			{name: "get missing field", tmpl: `[{{get .user "age"}}]`, want: "[]"},
+			{name: "get from missing object", tmpl: `[{{get .missing "age"}}]`, want: "[]"},
+			{name: "get element", tmpl: `{{get .user.roles -1}}`, want: "dev"},
+			{name: "dig", tmpl: `{{dig "user" "name" "unknown" .}}`, want: "alice"},
+			{name: "dig array", tmpl: `{{dig "user" "roles" "1" "none" .}}`, want: "dev"},
+			{name: "dig missing", tmpl: `{{dig "user" "address" "city" "unknown" .}}`, want: "unknown"},
+			{name: "dig out of range", tmpl: `{{dig "user" "roles" 5 "none" .}}`, want: "none"},
+			{name: "dig through scalar", tmpl: `{{dig "nick" "first" "none" .}}`, want: "none"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, exec(t, tt.tmpl, data))
+			})
+		}
+
+		tt, err := template.New("").Funcs(s.funcMap()).Parse(`{{dig "user" .}}`)
+		require.NoError(t, err)
+		require.ErrorContains(t, tt.Execute(&bytes.Buffer{}, data), "dig expects keys, the fallback and the value")
+	})
+
+	t.Run("uuidv4", func(t *testing.T) {
+		id, err := uuid.Parse(exec(t, `{{uuidv4}}`, nil))
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(4), id.Version())
+	})
+}
+
+func TestServer_env(t *testing.T) {
+	t.Setenv("REMAPJSON_TEST_API_KEY", "key")
+	t.Setenv("REMAPJSON_TEST_SECRET", "secret")
+
+	s := &Server{TemplateEnv: []string{"REMAPJSON_TEST_API_KEY", "REMAPJSON_TEST_UNSET"}}
+	tmpl := func(t *testing.T, name string) *template.Template {
+		t.Helper()
+		tt, err := template.New("").Funcs(s.funcMap()).Parse(`{{env "` + name + `"}}`)
+		require.NoError(t, err)
+		return tt
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, tmpl(t, "REMAPJSON_TEST_API_KEY").Execute(buf, nil))
+		assert.Equal(t, "key", buf.String())
+	})
+
+	t.Run("allowed, but unset", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, tmpl(t, "REMAPJSON_TEST_UNSET").Execute(buf, nil))
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := tmpl(t, "REMAPJSON_TEST_SECRET").Execute(buf, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `environment variable "REMAPJSON_TEST_SECRET" is not allowed`)
+		assert.NotContains(t, buf.String(), "secret")
+	})
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/go-pkgz/expirable-cache/v3"
+)
+
+// maxReplays is the maximum number of remembered remote responses,
+// the least recently used ones are evicted first.
+const maxReplays = 10000
+
+// replay is a remembered remote response.
+type replay struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// write answers the caller with the remembered response, marked
+// with the given header.
+func (rp replay) write(w http.ResponseWriter, marker string) {
+	copyHeaders(w.Header(), rp.header)
+	w.Header().Set(marker, "true")
+	w.WriteHeader(rp.status)
+	_, _ = w.Write(rp.body)
+}
+
+// replayKey returns the key to remember the response of the request under,
+// empty if idempotency is disabled or the request lacks the idempotency header.
+func (s *Server) replayKey(r *http.Request, cfg config.Webhook) string {
+	if s.IdempotencyTTL <= 0 || cfg.IdempotencyHeader == "" {
+		return ""
+	}
+	val := r.Header.Get(cfg.IdempotencyHeader)
+	if val == "" {
+		return ""
+	}
+	return r.PathValue("token") + "\n" + r.PathValue("rest") + "\n" + val
+}
+
+// replay returns the remembered response for the key, if any.
+func (s *Server) replay(key string) (replay, bool) {
+	if key == "" {
+		return replay{}, false
+	}
+	return s.replayCache().Get(key)
+}
+
+// remember stores the response to be replayed for the key.
+func (s *Server) remember(key string, rp replay) {
+	s.replayCache().Add(key, rp)
+}
+
+func (s *Server) replayCache() cache.Cache[string, replay] {
+	s.replaysOnce.Do(func() {
+		s.replays = cache.NewCache[string, replay]().WithLRU().WithMaxKeys(maxReplays).WithTTL(s.IdempotencyTTL)
+	})
+	return s.replays
+}
+
+// limitedBuffer records written bytes up to the limit and reports overflow,
+// writes never fail.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || int64(b.Len()+len(p)) > b.limit {
+		b.overflow = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"fmt"
+
+	jp "github.com/theory/jsonpath"
+)
+
+// jsonpath returns the values of the decoded JSON selected by the RFC 9535
+// JSONPath expression, e.g. "$.items[0].id" or "$.items[?@.price < 10].id".
+// Singular paths, i.e. consisting of names and indices only, return the
+// value itself, or nil if it is missing. Other paths, with wildcards,
+// slices, unions, filters or descendant segments, return the list of the
+// selected values, possibly empty. The RFC leaves the order of object
+// members unspecified, so are they selected in no particular order.
+func jsonpath(data any, expr string) (any, error) {
+	p, err := jp.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSONPath: %w", err)
+	}
+
+	nodes := p.Select(data)
+	if p.Query().Singular() == nil {
+		if nodes == nil {
+			return []any{}, nil
+		}
+		return []any(nodes), nil
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	return nodes[0], nil
+}
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONPath(t *testing.T) {
+	var data any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"specversion": "1.0",
+		"type": "order.created",
+		"data": {
+			"order": {"id": "o-1", "customer": {"name": "alice", "tags": ["vip", "eu"]}},
+			"items": [
+				{"id": "i-1", "price": 5, "qty": 2, "meta": {"gift": true}},
+				{"id": "i-2", "price": 12.5, "qty": 1, "meta": {"gift": false}},
+				{"id": "i-3", "price": 30, "qty": 3}
+			],
+			"currency": "EUR",
+			"threshold": 10,
+			"dotted.key": "d"
+		}
+	}`), &data))
+
+	tests := []struct {
+		name string
+		expr string
+		want any
+	}{
+		{name: "root", expr: "$.type", want: "order.created"},
+		{name: "nested", expr: "$.data.order.customer.name", want: "alice"},
+		{name: "bracket names", expr: `$['data']["order"].id`, want: "o-1"},
+		{name: "quoted name with dot", expr: `$.data['dotted.key']`, want: "d"},
+		{name: "array index", expr: "$.data.items[0].id", want: "i-1"},
+		{name: "negative index", expr: "$.data.items[-1].id", want: "i-3"},
+		{name: "index of nested array", expr: "$.data.order.customer.tags[1]", want: "eu"},
+		{name: "missing field", expr: "$.data.order.missing", want: nil},
+		{name: "index out of range", expr: "$.data.items[5]", want: nil},
+		{name: "wildcard", expr: "$.data.items[*].id", want: []any{"i-1", "i-2", "i-3"}},
+		{name: "dot wildcard", expr: "$.data.order.customer.tags.*", want: []any{"vip", "eu"}},
+		{name: "union", expr: "$.data.items[0,2].id", want: []any{"i-1", "i-3"}},
+		{name: "slice", expr: "$.data.items[1:].id", want: []any{"i-2", "i-3"}},
+		{name: "slice with step", expr: "$.data.items[::-2].id", want: []any{"i-3", "i-1"}},
+		{name: "descendants", expr: "$..name", want: []any{"alice"}},
+		{name: "descendants with index", expr: "$..items[1].id", want: []any{"i-2"}},
+		{name: "filter by number", expr: "$.data.items[?(@.price < 10)].id", want: []any{"i-1"}},
+		{name: "filter without parens", expr: "$.data.items[?@.qty >= 2].id", want: []any{"i-1", "i-3"}},
+		{name: "filter by string", expr: `$.data.items[?(@.id == 'i-2')].price`, want: []any{12.5}},
+		{name: "filter by existence", expr: "$.data.items[?(@.meta)].id", want: []any{"i-1", "i-2"}},
+		{name: "filter by absence", expr: "$.data.items[?(!@.meta)].id", want: []any{"i-3"}},
+		{name: "filter by false value exists", expr: "$.data.items[?(@.meta.gift)].id", want: []any{"i-1", "i-2"}},
+		{name: "filter by boolean", expr: "$.data.items[?(@.meta.gift == true)].id", want: []any{"i-1"}},
+		{name: "filter with logic", expr: "$.data.items[?(@.price > 10 && (@.qty == 1 || @.qty == 2))].id", want: []any{"i-2"}},
+		{name: "filter against root", expr: "$.data.items[?(@.price > $.data.threshold)].id", want: []any{"i-2", "i-3"}},
+		{name: "filter with function", expr: "$.data.items[?length(@.id) == 3 && match(@.id, 'i-[12]')].id", want: []any{"i-1", "i-2"}},
+		{name: "filter matches nothing", expr: "$.data.items[?(@.price > 100)].id", want: []any{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonpath(data, tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("invalid expressions", func(t *testing.T) {
+		for _, expr := range []string{"", "data.items", "$.", "$.items[", "$.items[]", "$.items[?(@.a ==)]",
+			"$.items[?(1)]", "$.items['id", "$.items[0", "$.items[?(@.a > 1]", "$ x"} {
+			_, err := jsonpath(data, expr)
+			assert.ErrorContains(t, err, "invalid JSONPath", expr)
+		}
+	})
+}
+
+// TestJSONPath_rfc9535 runs the examples of RFC 9535, section 1.5.
+func TestJSONPath_rfc9535(t *testing.T) {
+	var data any
+	require.NoError(t, json.Unmarshal([]byte(`{ "store": {
+		"book": [
+			{ "category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95 },
+			{ "category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99 },
+			{ "category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99 },
+			{ "category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99 }
+		],
+		"bicycle": { "color": "red", "price": 399 }
+	}}`), &data))
+
+	store := data.(map[string]any)["store"].(map[string]any)
+	books := store["book"].([]any)
+	authors := []any{"Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"}
+
+	tests := []struct {
+		expr      string
+		want      any
+		unordered bool // members of objects are selected in no particular order
+	}{
+		{expr: "$.store.book[*].author", want: authors},
+		{expr: "$..author", want: authors},
+		{expr: "$.store.*", want: []any{books, store["bicycle"]}, unordered: true},
+		{expr: "$.store..price", want: []any{8.95, 12.99, 8.99, 22.99, 399.0}, unordered: true},
+		{expr: "$..book[2]", want: []any{books[2]}},
+		{expr: "$..book[2].author", want: []any{"Herman Melville"}},
+		{expr: "$..book[2].publisher", want: []any{}},
+		{expr: "$..book[-1]", want: []any{books[3]}},
+		{expr: "$..book[0,1]", want: []any{books[0], books[1]}},
+		{expr: "$..book[:2]", want: []any{books[0], books[1]}},
+		{expr: "$..book[?@.isbn]", want: []any{books[2], books[3]}},
+		{expr: "$..book[?@.price<10]", want: []any{books[0], books[2]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := jsonpath(data, tt.expr)
+			require.NoError(t, err)
+			if tt.unordered {
+				assert.ElementsMatch(t, tt.want, got)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/cappuccinotm/slogx"
+)
+
+// maxLintDepth limits the nesting of the template invocations followed
+// by the linter, so that recursive partials don't loop forever.
+const maxLintDepth = 10
+
+// POST /lint - parses the template and reports the paths of the payload
+// fields it references, e.g. ".user.email", fields of the range elements
+// are reported with "[]", e.g. ".items[].id".
+// Accepts application/x-www-form-urlencoded with fields: template,
+// delim_left, delim_right, partial_name, partial_body.
+// Syntax errors are answered with 400 and their location in the details.
+func (s *Server) handleLint(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
+		return
+	}
+
+	partials, err := partialsFromForm(r)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
+		return
+	}
+
+	tmpl, err := s.parseTemplate(config.Webhook{Tmpl: r.FormValue("template"), Delims: delimsFromForm(r), Partials: partials})
+	if err != nil {
+		s.fail(w, r, &statusError{
+			status:  http.StatusBadRequest,
+			code:    codeTemplateError,
+			err:     fmt.Errorf("invalid template: %w", err),
+			details: parseErrorDetails(err),
+		})
+		return
+	}
+
+	resp := struct {
+		Fields []string `json:"fields"`
+	}{Fields: templateFields(tmpl)}
+	if resp.Fields == nil {
+		resp.Fields = []string{} // encoded as an empty list
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(r.Context(), "failed to write response", slogx.Error(err))
+	}
+}
+
+// parseErrorRe matches the text/template parse error message, e.g.
+// template: name:3: unexpected "}" in operand
+var parseErrorRe = regexp.MustCompile(`template: (.*?):(\d+):(?:\d+:)? (.*)$`)
+
+// parseErrorDetails extracts the location and the cause of the failure
+// from the template parse error.
+func parseErrorDetails(err error) templateError {
+	m := parseErrorRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return templateError{Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[2]) // matched as digits
+	return templateError{Template: m[1], Line: line, Message: m[3]}
+}
+
+// templateFields returns the sorted paths of the payload fields referenced
+// by the template. Fields accessed on an unknown dot, e.g. within a with
+// block over a function result, or through variables, are not reported.
+func templateFields(tmpl *template.Template) []string {
+	c := &fieldCollector{tmpl: tmpl, fields: map[string]struct{}{}}
+	if tmpl.Tree != nil {
+		c.walk(tmpl.Tree.Root, "", true)
+	}
+	return slices.Sorted(maps.Keys(c.fields))
+}
+
+// fieldCollector walks the template tree, keeping track of the dot.
+type fieldCollector struct {
+	tmpl   *template.Template
+	fields map[string]struct{}
+	depth  int
+}
+
+// walk collects the fields referenced by the node, dot is the path
+// of the dot, known is false if the dot is not a payload field.
+func (c *fieldCollector) walk(node parse.Node, dot string, known bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, dot, known)
+		}
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, dot, known)
+	case *parse.IfNode:
+		c.pipe(n.Pipe, dot, known)
+		c.walk(n.List, dot, known)
+		c.walk(n.ElseList, dot, known)
+	case *parse.WithNode:
+		c.pipe(n.Pipe, dot, known)
+		inner, innerKnown := c.pipeDot(n.Pipe, dot, known)
+		c.walk(n.List, inner, innerKnown)
+		c.walk(n.ElseList, dot, known)
+	case *parse.RangeNode:
+		c.pipe(n.Pipe, dot, known)
+		inner, innerKnown := c.pipeDot(n.Pipe, dot, known)
+		c.walk(n.List, inner+"[]", innerKnown)
+		c.walk(n.ElseList, dot, known)
+	case *parse.TemplateNode:
+		c.pipe(n.Pipe, dot, known)
+		invoked := c.tmpl.Lookup(n.Name)
+		if invoked == nil || invoked.Tree == nil || c.depth >= maxLintDepth {
+			return
+		}
+		inner, innerKnown := c.pipeDot(n.Pipe, dot, known)
+		c.depth++
+		c.walk(invoked.Tree.Root, inner, innerKnown)
+		c.depth--
+	}
+}
+
+// pipe collects the fields referenced by the arguments of the pipeline.
+func (c *fieldCollector) pipe(p *parse.PipeNode, dot string, known bool) {
+	if p == nil {
+		return
+	}
+	for _, cmd := range p.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				if known {
+					c.add(dot, a.Ident)
+				}
+			case *parse.VariableNode:
+				if a.Ident[0] == "$" && len(a.Ident) > 1 {
+					c.add("", a.Ident[1:])
+				}
+			case *parse.ChainNode:
+				if inner, ok := a.Node.(*parse.PipeNode); ok {
+					c.pipe(inner, dot, known)
+				}
+			case *parse.PipeNode:
+				c.pipe(a, dot, known)
+			}
+		}
+	}
+}
+
+// pipeDot returns the path of the dot set by the with, range or template
+// pipeline, known is false if the pipeline is not a plain field access.
+func (c *fieldCollector) pipeDot(p *parse.PipeNode, dot string, known bool) (string, bool) {
+	if p == nil || len(p.Cmds) != 1 || len(p.Cmds[0].Args) != 1 {
+		return "", false
+	}
+	switch a := p.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot, known
+	case *parse.FieldNode:
+		return dot + "." + strings.Join(a.Ident, "."), known
+	case *parse.VariableNode:
+		if a.Ident[0] == "$" {
+			return strings.Join(append([]string{""}, a.Ident[1:]...), "."), true
+		}
+	}
+	return "", false
+}
+
+// add records the field path, the reserved keys are not payload fields.
+func (c *fieldCollector) add(dot string, ident []string) {
+	if dot == "" && slices.Contains([]string{rawKey, pathKey, headersKey, constKey}, ident[0]) {
+		return
+	}
+	c.fields[dot+"."+strings.Join(ident, ".")] = struct{}{}
+}
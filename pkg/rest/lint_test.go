@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLint(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test"}
+
+	lint := func(form neturl.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/lint", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.handleLint(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		form neturl.Values
+		want []string
+	}{
+		{
+			name: "plain fields",
+			form: neturl.Values{"template": {`{"email": "{{.user.email}}", "id": {{.id}}, "n": {{len .tags}}}`}},
+			want: []string{".id", ".tags", ".user.email"},
+		},
+		{
+			name: "with and range change the dot",
+			form: neturl.Values{"template": {
+				`{{with .user}}{{.name}}{{else}}{{.anonymous}}{{end}}{{range .items}}{{.id}}{{$.total}}{{end}}`,
+			}},
+			want: []string{".anonymous", ".items", ".items[].id", ".total", ".user", ".user.name"},
+		},
+		{
+			name: "unknown dot is skipped",
+			form: neturl.Values{"template": {`{{with (index .list 0)}}{{.name}}{{end}}{{$u := .user}}{{$u.name}}`}},
+			want: []string{".list", ".user"},
+		},
+		{
+			name: "conditions and nested pipelines",
+			form: neturl.Values{"template": {`{{if eq .type "ping"}}{{printf "%s" (print .name)}}{{end}}`}},
+			want: []string{".name", ".type"},
+		},
+		{
+			name: "partials are followed with their dot",
+			form: neturl.Values{
+				"template":     {`{{template "user" .author}}{{range .assignees}}{{template "user" .}}{{end}}`},
+				"partial_name": {"user"},
+				"partial_body": {`{"login": "{{.login}}"}`},
+			},
+			want: []string{".assignees", ".assignees[].login", ".author", ".author.login"},
+		},
+		{
+			name: "reserved keys are not payload fields",
+			form: neturl.Values{"template": {`{{rawJSON ._raw}}{{index ._path 0}}`}},
+			want: []string{},
+		},
+		{
+			name: "custom delimiters",
+			form: neturl.Values{"template": {`{"v": <<.value>>}`}, "delim_left": {"<<"}, "delim_right": {">>"}},
+			want: []string{".value"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := lint(tt.form)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			var resp struct {
+				Fields []string `json:"fields"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, tt.want, resp.Fields)
+		})
+	}
+
+	t.Run("syntax error is reported with line", func(t *testing.T) {
+		rec := lint(neturl.Values{"template": {"{\n  \"v\": {{.value}\n}"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var resp struct {
+			Error   string        `json:"error"`
+			Details templateError `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Contains(t, resp.Error, "invalid template")
+		assert.Equal(t, 2, resp.Details.Line)
+		assert.Equal(t, "bad character U+007D '}'", resp.Details.Message)
+	})
+
+	t.Run("syntax error in partial is reported with its name", func(t *testing.T) {
+		rec := lint(neturl.Values{"template": {`{{template "user" .}}`}, "partial_name": {"user"}, "partial_body": {"\n{{if}}"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var resp struct {
+			Details templateError `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "user", resp.Details.Template)
+		assert.Equal(t, 2, resp.Details.Line)
+		assert.Equal(t, "missing value for if", resp.Details.Message)
+	})
+}
@@ -1,11 +1,21 @@
 package rest
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"runtime/debug"
+	"slices"
+	"strings"
 
 	"github.com/cappuccinotm/slogx/slogm"
+	"github.com/didip/tollbooth/v8"
+	"github.com/didip/tollbooth/v8/limiter"
+	R "github.com/go-pkgz/rest"
 	"github.com/google/uuid"
 )
 
@@ -50,3 +60,256 @@ func AssignRequestID(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// auth is a middleware that protects the web API with the Basic Auth password
+// and the API key in the X-API-Key header, either is accepted if configured.
+// Requests with the API key skip Basic Auth, so that automation doesn't get
+// the browser prompt, while a wrong key is rejected without falling back.
+func (s *Server) auth(next http.Handler) http.Handler {
+	basic := R.BasicAuthWithPrompt("remapjson", s.Password)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		switch {
+		case s.APIKey != "" && key != "":
+			if subtle.ConstantTimeCompare([]byte(key), []byte(s.APIKey)) != 1 {
+				s.error(w, r, http.StatusUnauthorized, codeUnauthorized, "invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		case s.Password != "":
+			basic.ServeHTTP(w, r)
+		case s.APIKey != "":
+			s.error(w, r, http.StatusUnauthorized, codeUnauthorized, "missing API key")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// realIP is a middleware that sets the remote address of the request to the
+// client IP. X-Forwarded-For and X-Real-IP are honored only if the direct peer
+// is one of the trusted proxies, otherwise the socket address is used.
+// Without trusted proxies, the headers are trusted as is, see R.RealIP.
+// The address of the direct peer is kept in the request context.
+func (s *Server) realIP(next http.Handler) http.Handler {
+	headersOnly := R.RealIP(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), peerKey{}, r.RemoteAddr))
+		if len(s.TrustedProxies) == 0 {
+			headersOnly.ServeHTTP(w, r)
+			return
+		}
+		if ip, ok := s.clientIP(r); ok {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// peerKey is the context key of the address of the direct peer.
+type peerKey struct{}
+
+// sourceIP returns the IP of the client to check against the allowlists.
+// Without trusted proxies the forwarded headers can be spoofed by anyone,
+// so the address of the direct peer is used instead, unless the peer has
+// no IP, i.e. it is the local proxy connected to the Unix socket.
+func (s *Server) sourceIP(r *http.Request) (netip.Addr, error) {
+	if len(s.TrustedProxies) > 0 {
+		return parseAddr(r.RemoteAddr)
+	}
+	if peer, ok := r.Context().Value(peerKey{}).(string); ok {
+		if addr, err := parseAddr(peer); err == nil {
+			return addr, nil
+		}
+	}
+	return parseAddr(r.RemoteAddr)
+}
+
+// clientIP returns the IP of the client, which sent the request through
+// the trusted proxies, if any. X-Forwarded-For is walked from the right,
+// as the rightmost entries are appended by the proxies closest to us.
+func (s *Server) clientIP(r *http.Request) (string, bool) {
+	peer, err := parseAddr(r.RemoteAddr)
+	if err != nil {
+		return "", false
+	}
+	if !s.trustedProxy(peer) {
+		return peer.String(), true
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break // the chain can't be trusted past a malformed entry
+		}
+		if !s.trustedProxy(hop) {
+			return hop.String(), true
+		}
+	}
+
+	if ip, err := parseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.String(), true
+	}
+	return peer.String(), true
+}
+
+// trustedProxy reports whether the address belongs to a trusted proxy.
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	return slices.ContainsFunc(s.TrustedProxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// parseAddr parses the IP address, with or without the port.
+func parseAddr(s string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr.Unmap(), err
+}
+
+// trackInFlight is a middleware that counts the requests being handled.
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// throttle is a middleware that limits the number of requests handled
+// at once, the rest are rejected with 503 Service Unavailable.
+func (s *Server) throttle(limit int) func(http.Handler) http.Handler {
+	sem := make(chan struct{}, limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Retry-After", "1")
+				s.error(w, r, http.StatusServiceUnavailable, codeOverloaded, "too many requests in flight")
+			}
+		})
+	}
+}
+
+// rateLimit returns a middleware that limits the requests per client IP
+// to the given rate. The remaining requests of the client are reported
+// in the X-RateLimit-Remaining header, rejected requests are answered
+// with 429 Too Many Requests and the Retry-After header.
+func (s *Server) rateLimit(rps float64) func(http.Handler) http.Handler {
+	lmt := s.limiter(rps)
+	lmt.SetIPLookup(limiter.IPLookup{Name: "RemoteAddr"})
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if herr := tollbooth.LimitByRequest(lmt, w, r); herr != nil {
+				slog.WarnContext(r.Context(), "rate limit exceeded", slog.String("remote", r.RemoteAddr))
+				w.Header().Set("Retry-After", retryAfter(rps))
+				s.error(w, r, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+				return
+			}
+			// set by the limiter, following the IETF draft, named as most of the senders expect
+			if left := w.Header().Get("RateLimit-Remaining"); left != "" {
+				w.Header().Set("X-RateLimit-Remaining", left)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sizeLimit is a middleware that rejects requests with bodies larger than
+// the configured maximum body size with 413 Request Entity Too Large.
+func (s *Server) sizeLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := s.maxBodySize()
+
+		if r.ContentLength > size {
+			s.error(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body is larger than %d bytes", size)
+			return
+		}
+
+		content, err := io.ReadAll(io.LimitReader(r.Body, size+1))
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "failed to read request body: %v", err)
+			return
+		}
+		_ = r.Body.Close() // the original body is already consumed
+
+		if int64(len(content)) > size {
+			s.error(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body is larger than %d bytes", size)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(content))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORS configures cross-origin access to the web API.
+type CORS struct {
+	Origins []string // allowed origins, "*" allows any, empty disables CORS
+	Methods []string // allowed methods, defaults to GET and POST
+	Headers []string // allowed request headers, defaults to the ones used by the web UI
+}
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization", "X-API-Key", "Accept",
+		"HX-Request", "HX-Current-URL", "HX-Target", "HX-Trigger", "HX-Trigger-Name"}
+)
+
+// Handler is a middleware that sets CORS headers for the allowed origins
+// and answers preflight requests. It must go before the authentication,
+// as preflight requests carry no credentials.
+func (c CORS) Handler(next http.Handler) http.Handler {
+	methods, headers := c.Methods, c.Headers
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		switch {
+		case origin == "":
+		case c.allowOrigin(w, origin):
+		case preflight:
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowOrigin sets the Access-Control-Allow-Origin header if the origin
+// is allowed and reports whether it is. Credentials are allowed only for
+// the listed origins.
+func (c CORS) allowOrigin(w http.ResponseWriter, origin string) bool {
+	switch {
+	case slices.Contains(c.Origins, origin):
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	case slices.Contains(c.Origins, "*"):
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	default:
+		return false
+	}
+	return true
+}
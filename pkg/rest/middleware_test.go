@@ -1,8 +1,11 @@
 package rest
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -80,3 +83,257 @@ func TestAssignRequestID(t *testing.T) {
 		}
 	})
 }
+
+func TestServer_trackInFlight(t *testing.T) {
+	s := &Server{}
+	handler := s.trackInFlight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, int64(1), s.inFlight.Load())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	assert.Equal(t, int64(0), s.inFlight.Load())
+}
+
+func TestServer_throttle(t *testing.T) {
+	s := &Server{}
+	release, entered := make(chan struct{}), make(chan struct{}, 2)
+	handler := s.throttle(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "too many requests in flight", "code": "overloaded"}`, rec.Body.String())
+
+	close(release)
+	<-done
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code, "slot is freed after the request")
+}
+
+func TestServer_auth(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		apiKey   string
+		user     string // basic auth password, if set
+		key      string // X-API-Key header, if set
+		want     int
+	}{
+		{name: "no auth configured", want: http.StatusOK},
+		{name: "password", password: "pass", user: "pass", want: http.StatusOK},
+		{name: "wrong password", password: "pass", user: "wrong", want: http.StatusUnauthorized},
+		{name: "password with API key", password: "pass", apiKey: "key", user: "pass", want: http.StatusOK},
+		{name: "API key instead of password", password: "pass", apiKey: "key", key: "key", want: http.StatusOK},
+		{name: "wrong API key", password: "pass", apiKey: "key", key: "wrong", user: "pass", want: http.StatusUnauthorized},
+		{name: "API key only", apiKey: "key", key: "key", want: http.StatusOK},
+		{name: "missing API key", apiKey: "key", want: http.StatusUnauthorized},
+		{name: "API key ignored if not configured", password: "pass", key: "key", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Password: tt.password, APIKey: tt.apiKey}
+			handler := s.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if tt.user != "" {
+				req.SetBasicAuth("remapjson", tt.user)
+			}
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+			if tt.password == "" && tt.want == http.StatusUnauthorized {
+				assert.Empty(t, rec.Header().Get("WWW-Authenticate"), "no browser prompt without password")
+			}
+		})
+	}
+}
+
+func TestServer_realIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []netip.Prefix
+		remoteAddr string
+		xff        string
+		xRealIP    string
+		want       string
+	}{
+		{
+			name:       "headers are trusted without trusted proxies",
+			remoteAddr: "203.0.113.1:1234",
+			xff:        "198.51.100.7",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "headers from untrusted peer are ignored",
+			trusted:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			remoteAddr: "203.0.113.1:1234",
+			xff:        "198.51.100.7",
+			xRealIP:    "198.51.100.8",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "rightmost untrusted hop is the client",
+			trusted:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			remoteAddr: "10.0.0.2:1234",
+			xff:        "1.1.1.1, 198.51.100.7, 10.0.0.1",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "X-Real-IP from trusted peer",
+			trusted:    []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32")},
+			remoteAddr: "10.0.0.2:1234",
+			xRealIP:    "198.51.100.8",
+			want:       "198.51.100.8",
+		},
+		{
+			name:       "trusted peer without headers",
+			trusted:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			remoteAddr: "10.0.0.2:1234",
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "malformed hop stops the chain",
+			trusted:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			remoteAddr: "10.0.0.2:1234",
+			xff:        "198.51.100.7, garbage",
+			want:       "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{TrustedProxies: tt.trusted}
+
+			var got string
+			handler := s.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.RemoteAddr }))
+
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xRealIP != "" {
+				req.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServer_sizeLimit(t *testing.T) {
+	s := &Server{MaxBodySize: 10}
+	var called bool
+	handler := s.sizeLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "0123456789", string(b))
+	}))
+
+	t.Run("body within the limit passes", func(t *testing.T) {
+		called = false
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, called)
+	})
+
+	t.Run("body over the limit is rejected", func(t *testing.T) {
+		called = false
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789A")))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error"`)
+		assert.False(t, called)
+	})
+
+	t.Run("body without content length over the limit is rejected", func(t *testing.T) {
+		called = false
+		req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("0123456789A")))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.False(t, called)
+	})
+}
+
+func TestCORS_Handler(t *testing.T) {
+	var called bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	request := func(method, origin string, preflight bool) *http.Request {
+		req := httptest.NewRequest(method, "/configure", http.NoBody)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		return req
+	}
+
+	t.Run("allowed origin", func(t *testing.T) {
+		h := CORS{Origins: []string{"https://ui.example.com"}}.Handler(next)
+
+		called = false
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, request(http.MethodPost, "https://ui.example.com", false))
+		assert.True(t, called)
+		assert.Equal(t, "https://ui.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+		called = false
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, request(http.MethodOptions, "https://ui.example.com", true))
+		assert.False(t, called)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "GET, POST", rec.Header().Get("Access-Control-Allow-Methods"))
+		assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "HX-Request")
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		h := CORS{Origins: []string{"https://ui.example.com"}}.Handler(next)
+
+		called = false
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, request(http.MethodPost, "https://evil.example.com", false))
+		assert.True(t, called, "the browser blocks the response without CORS headers")
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+		called = false
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, request(http.MethodOptions, "https://evil.example.com", true))
+		assert.False(t, called)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		h := CORS{Origins: []string{"*"}, Methods: []string{http.MethodPost}, Headers: []string{"X-Custom"}}.Handler(next)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, request(http.MethodOptions, "https://any.example.com", true))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "POST", rec.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "X-Custom", rec.Header().Get("Access-Control-Allow-Headers"))
+	})
+}
@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// formPart is a part of the multipart/form-data body, as described by
+// the rendered template. Parts with a filename are files, others are
+// plain form fields.
+type formPart struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Base64      bool   `json:"base64,omitempty"` // value is base64-encoded, e.g. binary file content
+}
+
+// buildMultipart builds the multipart/form-data body from the rendered
+// JSON array of parts, returning the body and its content type with the
+// boundary. The parts are written in the order of the array.
+func buildMultipart(spec []byte) (body []byte, contentType string, err error) {
+	dec := json.NewDecoder(bytes.NewReader(spec))
+	dec.DisallowUnknownFields()
+	var parts []formPart
+	if err = dec.Decode(&parts); err != nil {
+		return nil, "", fmt.Errorf("expected JSON array of parts: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, "", errors.New("no parts")
+	}
+
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for i, part := range parts {
+		if part.Name == "" {
+			return nil, "", fmt.Errorf("part %d: missing name", i)
+		}
+
+		value := []byte(part.Value)
+		if part.Base64 {
+			if value, err = decodeBase64(part.Value); err != nil {
+				return nil, "", fmt.Errorf("part %q: value is not valid base64: %w", part.Name, err)
+			}
+		}
+
+		if err = writePart(mw, part, value); err != nil {
+			return nil, "", fmt.Errorf("part %q: %w", part.Name, err)
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// writePart writes the part to the multipart body, files default
+// to application/octet-stream, fields have no content type by default.
+func writePart(mw *multipart.Writer, part formPart, value []byte) error {
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(part.Name))
+	contentType := part.ContentType
+	if part.Filename != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(part.Filename))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+
+	h := textproto.MIMEHeader{"Content-Disposition": {disposition}}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(value)
+	return err
+}
+
+// quoteEscaper escapes the quoted parameters of Content-Disposition,
+// the same way as mime/multipart does.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMultipart(t *testing.T) {
+	t.Run("fields and files", func(t *testing.T) {
+		body, contentType, err := buildMultipart([]byte(`[
+			{"name": "channels", "value": "C123"},
+			{"name": "file", "filename": "report \"q1\".csv", "content_type": "text/csv", "value": "a,b\n1,2\n"},
+			{"name": "image", "filename": "dot.png", "value": "iVBORw0K", "base64": true}
+		]`))
+		require.NoError(t, err)
+
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/form-data", mediaType)
+
+		type part struct{ name, filename, contentType, value string }
+		var got []part
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			b, err := io.ReadAll(p)
+			require.NoError(t, err)
+			got = append(got, part{name: p.FormName(), filename: p.FileName(), contentType: p.Header.Get("Content-Type"), value: string(b)})
+		}
+
+		assert.Equal(t, []part{
+			{name: "channels", value: "C123"},
+			{name: "file", filename: `report "q1".csv`, contentType: "text/csv", value: "a,b\n1,2\n"},
+			{name: "image", filename: "dot.png", contentType: "application/octet-stream", value: "\x89PNG\r\n"},
+		}, got)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			spec    string
+			wantErr string
+		}{
+			{name: "not an array", spec: `{"name": "a"}`, wantErr: "expected JSON array of parts"},
+			{name: "unknown field", spec: `[{"name": "a", "filname": "a.txt"}]`, wantErr: `unknown field "filname"`},
+			{name: "empty", spec: `[]`, wantErr: "no parts"},
+			{name: "missing name", spec: `[{"value": "a"}]`, wantErr: "part 0: missing name"},
+			{name: "invalid base64", spec: `[{"name": "f", "value": "!", "base64": true}]`, wantErr: `part "f": value is not valid base64`},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, _, err := buildMultipart([]byte(tt.spec))
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
+}
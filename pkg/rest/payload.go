@@ -0,0 +1,292 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// decompress wraps the body reader to decode the given Content-Encoding.
+func decompress(body io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		return zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// minCompressSize is the size of the outbound body, below which it is not
+// compressed, as gzip overhead outweighs the savings for small bodies.
+const minCompressSize = 1024
+
+// compress gzips the outbound body.
+func compress(body []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodePayload decodes the incoming payload according to its content type.
+// URL-encoded and multipart forms are decoded into an object of form fields,
+// everything else is decoded as JSON, tolerating comments and trailing
+// commas if lenient, and nested no deeper than maxDepth, if positive.
+func decodePayload(body []byte, contentType string, lenient bool, maxDepth int) (any, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil { // missing or malformed content type, assume JSON
+		mediaType = ""
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form: %w", err)
+		}
+		return formFields(values), nil
+	case "multipart/form-data":
+		data, err := decodeMultipart(body, params["boundary"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		return data, nil
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		data, err := decodeYAML(body, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		return data, nil
+	default:
+		if lenient {
+			body = relaxJSON(body)
+		}
+		if maxDepth > 0 {
+			if err := checkDepth(body, maxDepth); err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+		}
+		data, err := decodeJSON(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return data, nil
+	}
+}
+
+// checkDepth returns an error if the arrays and objects of the JSON document
+// are nested deeper than the limit. It scans the bytes without decoding them,
+// so that the pathologically nested documents are rejected before the decoder
+// allocates anything for them. Malformed documents are left to the decoder.
+func checkDepth(body []byte, limit int) error {
+	depth, inString := 0, false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++ // skip the escaped character, e.g. a quote
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			if depth++; depth > limit {
+				return fmt.Errorf("nesting depth exceeds %d", limit)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// decodeMultipart decodes the multipart form into an object of form fields.
+// Files are not exposed to the template, only their metadata:
+// filename, content_type and size.
+func decodeMultipart(body []byte, boundary string) (map[string]any, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("missing boundary")
+	}
+
+	// body is already in memory, so keep the form in memory as well
+	form, err := multipart.NewReader(bytes.NewReader(body), boundary).ReadForm(int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	defer form.RemoveAll() //nolint:errcheck // nothing is stored on disk
+
+	data := formFields(form.Value)
+	for name, headers := range form.File {
+		files := make([]any, 0, len(headers))
+		for _, fh := range headers {
+			files = append(files, map[string]any{
+				"filename":     fh.Filename,
+				"content_type": fh.Header.Get("Content-Type"),
+				"size":         float64(fh.Size),
+			})
+		}
+		data[name] = flatten(files)
+	}
+
+	return data, nil
+}
+
+// formFields converts form values into an object, fields with
+// a single value are flattened, others are kept as arrays.
+func formFields(values map[string][]string) map[string]any {
+	data := make(map[string]any, len(values))
+	for k, vs := range values {
+		vals := make([]any, len(vs))
+		for i, v := range vs {
+			vals[i] = v
+		}
+		data[k] = flatten(vals)
+	}
+	return data
+}
+
+// flatten returns the only element of the slice, or the slice itself.
+func flatten(vals []any) any {
+	if len(vals) == 1 {
+		return vals[0]
+	}
+	return vals
+}
+
+// project returns a copy of the decoded JSON object with only the fields
+// at the given dot-separated paths. Paths missing in the object are skipped.
+func project(data any, paths []string) (any, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	obj, ok := data.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %s", jsonKind(data))
+	}
+
+	res := map[string]any{}
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		if v, found := lookup(obj, keys); found {
+			assign(res, keys, v)
+		}
+	}
+
+	return res, nil
+}
+
+// lookup returns the value at the given path in the nested object.
+func lookup(obj map[string]any, keys []string) (any, bool) {
+	var cur any = obj
+	for _, key := range keys {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// assign sets the value at the given path in the nested object,
+// creating intermediate objects as needed.
+func assign(obj map[string]any, keys []string, v any) {
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			obj[key] = next
+		}
+		obj = next
+	}
+	obj[keys[len(keys)-1]] = v
+}
+
+// relaxJSON makes the JSON5-style input parseable by the standard decoder:
+// strips // line and /* block */ comments and drops trailing commas before
+// closing brackets. Strings are kept intact, the rest of the input is not
+// validated, leaving that to the decoder.
+func relaxJSON(b []byte) []byte {
+	stripped := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '"':
+			end := stringEnd(b, i)
+			stripped = append(stripped, b[i:end]...)
+			i = end - 1
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			stripped = append(stripped, '\n')
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end == -1 {
+				return b // unterminated comment, let the decoder fail on it
+			}
+			i += end + 3
+			stripped = append(stripped, ' ')
+		default:
+			stripped = append(stripped, b[i])
+		}
+	}
+
+	res := make([]byte, 0, len(stripped))
+	for i := 0; i < len(stripped); i++ {
+		switch stripped[i] {
+		case '"':
+			end := stringEnd(stripped, i)
+			res = append(res, stripped[i:end]...)
+			i = end - 1
+		case ',':
+			prev := bytes.TrimRight(res, " \t\r\n")
+			next := bytes.TrimLeft(stripped[i+1:], " \t\r\n")
+			trailing := len(next) > 0 && (next[0] == '}' || next[0] == ']')
+			if trailing && len(prev) > 0 && !bytes.ContainsAny(prev[len(prev)-1:], "[{,") {
+				continue // trailing comma after a value
+			}
+			res = append(res, ',')
+		default:
+			res = append(res, stripped[i])
+		}
+	}
+	return res
+}
+
+// stringEnd returns the index after the closing quote of the JSON string
+// starting at i, or the length of the input if the string is unterminated.
+func stringEnd(b []byte, i int) int {
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			j++ // skip the escaped character
+		case '"':
+			return j + 1
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"bytes"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProject(t *testing.T) {
+	data := map[string]any{
+		"user":     map[string]any{"id": 1.0, "email": "a@b.c", "password": "secret"},
+		"event":    "signup",
+		"internal": true,
+	}
+
+	t.Run("keeps only the listed paths", func(t *testing.T) {
+		res, err := project(data, []string{"user.id", "user.email", "event", "missing.path"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"user":  map[string]any{"id": 1.0, "email": "a@b.c"},
+			"event": "signup",
+		}, res)
+	})
+
+	t.Run("whole nested object", func(t *testing.T) {
+		res, err := project(data, []string{"user"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"user": data["user"]}, res)
+	})
+
+	t.Run("nil data", func(t *testing.T) {
+		res, err := project(nil, []string{"user"})
+		require.NoError(t, err)
+		assert.Nil(t, res)
+	})
+
+	t.Run("non-object data", func(t *testing.T) {
+		_, err := project([]any{1.0}, []string{"user"})
+		assert.EqualError(t, err, "expected an object, got array")
+	})
+}
+
+func TestDecodePayload(t *testing.T) {
+	t.Run("JSON by default", func(t *testing.T) {
+		for _, ct := range []string{"", "application/json", "text/plain; charset=utf-8", "malformed;;"} {
+			data, err := decodePayload([]byte(`{"a":1}`), ct, false, 0)
+			require.NoError(t, err, ct)
+			assert.Equal(t, map[string]any{"a": 1.0}, data, ct)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := decodePayload([]byte(`{`), "application/json", false, 0)
+		assert.ErrorContains(t, err, "invalid JSON")
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		for _, ct := range []string{"application/yaml", "application/x-yaml", "text/yaml; charset=utf-8"} {
+			data, err := decodePayload([]byte("a: 1\nb: [x]\n"), ct, false, 0)
+			require.NoError(t, err, ct)
+			assert.Equal(t, map[string]any{"a": 1.0, "b": []any{"x"}}, data, ct)
+		}
+
+		_, err := decodePayload([]byte("a: [\n"), "application/yaml", false, 0)
+		assert.ErrorContains(t, err, "invalid YAML")
+	})
+
+	t.Run("lenient JSON", func(t *testing.T) {
+		body := []byte(`{
+			// line comment
+			"a": 1, /* block comment */
+			"url": "http://example.com/*not a comment*/",
+			"quote": "say \"hi\", // still a string",
+			"items": [1, 2, 3,],
+		}`)
+
+		_, err := decodePayload(body, "application/json", false, 0)
+		require.Error(t, err, "strict by default")
+
+		data, err := decodePayload(body, "application/json", true, 0)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"a":     1.0,
+			"url":   "http://example.com/*not a comment*/",
+			"quote": `say "hi", // still a string`,
+			"items": []any{1.0, 2.0, 3.0},
+		}, data)
+	})
+
+	t.Run("lenient JSON keeps invalid input invalid", func(t *testing.T) {
+		for _, body := range []string{`{"a": 1 /* unterminated`, `{"a": 1} /* unterminated`, `[,]`, `{"a": "unterminated}`} {
+			_, err := decodePayload([]byte(body), "application/json", true, 0)
+			assert.ErrorContains(t, err, "invalid JSON", body)
+		}
+	})
+
+	t.Run("JSON nesting depth", func(t *testing.T) {
+		nested := func(n int) []byte {
+			return []byte(strings.Repeat(`{"a":[`, n) + "1" + strings.Repeat("]}", n))
+		}
+
+		_, err := decodePayload(nested(2), "application/json", false, 4)
+		require.NoError(t, err)
+
+		_, err = decodePayload(nested(3), "application/json", false, 4)
+		assert.ErrorContains(t, err, "invalid JSON: nesting depth exceeds 4")
+
+		_, err = decodePayload(nested(3), "application/json", false, 0)
+		require.NoError(t, err, "no limit")
+
+		// brackets in strings, including the ones after escaped quotes, aren't nesting
+		data, err := decodePayload([]byte(`{"a": "[[[{{{", "b": "\"[[[\\"}`), "application/json", false, 1)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"a": "[[[{{{", "b": `"[[[\`}, data)
+	})
+
+	t.Run("YAML nesting depth", func(t *testing.T) {
+		const ct = "application/yaml"
+		body := []byte("a:\n  b:\n    - c: 1\n") // object, object, array, object
+
+		data, err := decodePayload(body, ct, false, 4)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"a": map[string]any{"b": []any{map[string]any{"c": 1.0}}}}, data)
+
+		_, err = decodePayload(body, ct, false, 3)
+		assert.EqualError(t, err, "invalid YAML: nesting depth exceeds 3")
+
+		_, err = decodePayload([]byte("[[[[[1]]]]]"), ct, false, 4)
+		assert.EqualError(t, err, "invalid YAML: nesting depth exceeds 4")
+
+		_, err = decodePayload([]byte("a: 1"), ct, false, 1)
+		require.NoError(t, err)
+	})
+
+	t.Run("url-encoded form", func(t *testing.T) {
+		data, err := decodePayload([]byte("text=hello+world&tag=a&tag=b"), "application/x-www-form-urlencoded", false, 0)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"text": "hello world", "tag": []any{"a", "b"}}, data)
+	})
+
+	t.Run("invalid url-encoded form", func(t *testing.T) {
+		_, err := decodePayload([]byte("text=%zz"), "application/x-www-form-urlencoded", false, 0)
+		assert.ErrorContains(t, err, "invalid form")
+	})
+
+	t.Run("multipart form", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		require.NoError(t, mw.WriteField("text", "hello"))
+		fw, err := mw.CreateFormFile("attachment", "report.csv")
+		require.NoError(t, err)
+		_, err = fw.Write([]byte("a,b,c"))
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+
+		data, err := decodePayload(buf.Bytes(), mw.FormDataContentType(), false, 0)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"text": "hello",
+			"attachment": map[string]any{
+				"filename":     "report.csv",
+				"content_type": "application/octet-stream",
+				"size":         5.0,
+			},
+		}, data)
+	})
+
+	t.Run("multipart form without boundary", func(t *testing.T) {
+		_, err := decodePayload([]byte("whatever"), "multipart/form-data", false, 0)
+		assert.EqualError(t, err, "invalid multipart form: missing boundary")
+	})
+}
@@ -0,0 +1,114 @@
+package rest
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxProbeBody is the maximum size of the response body shown by /test,
+// the rest of it is cut off.
+const maxProbeBody = 4 << 10
+
+// probedKey marks the context of the webhook requests sent by /test.
+type probedKey struct{}
+
+// probed reports whether the webhook request is a test one, sent by /test.
+func probed(ctx context.Context) bool {
+	v, _ := ctx.Value(probedKey{}).(bool)
+	return v
+}
+
+// probeWriter captures the response of the test webhook request,
+// keeping at most maxProbeBody bytes of the body.
+type probeWriter struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *probeWriter) Header() http.Header { return w.header }
+
+func (w *probeWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *probeWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if n := maxProbeBody - w.body.Len(); len(p) > n {
+		w.body.Write(p[:n])
+		w.truncated = true
+		return len(p), nil
+	}
+	return w.body.Write(p)
+}
+
+// POST /test - sends the sample payload to the webhook of the token (or full
+// webhook URL) and reports the status and the beginning of the response body
+// as an HTML fragment. The payload goes through the same pipeline as the real
+// deliveries and is sent to the real remote, except the inbound signature,
+// which is not verified. With dry_run=true, and dry runs allowed, the
+// outbound request is rendered without being sent.
+// Accepts application/x-www-form-urlencoded with fields: token, data,
+// method (defaults to POST), dry_run.
+func (s *Server) handleTest(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		//nolint:gosec // error message is escaped with html.EscapeString
+		fmt.Fprintf(w, `<span class="error">invalid form: %s</span>`, html.EscapeString(err.Error()))
+		return
+	}
+
+	raw := r.FormValue("token")
+	if raw == "" {
+		fmt.Fprint(w, `<span class="error">missing token</span>`)
+		return
+	}
+
+	token, path := webhookFromInput(raw)
+	rest := strings.Join(path, "/")
+	method := cmp.Or(strings.ToUpper(r.FormValue("method")), http.MethodPost)
+
+	ctx := context.WithValue(r.Context(), probedKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, method, "/wh/"+token+"/"+rest, strings.NewReader(r.FormValue("data")))
+	if err != nil {
+		//nolint:gosec // error message is escaped with html.EscapeString
+		fmt.Fprintf(w, `<span class="error">invalid request: %s</span>`, html.EscapeString(err.Error()))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.FormValue("dry_run") == "true" {
+		req.Header.Set("X-RemapJSON-DryRun", "true")
+	}
+	req.RemoteAddr = r.RemoteAddr
+	req.SetPathValue("token", token)
+	req.SetPathValue("rest", rest)
+
+	slog.InfoContext(ctx, "sending test webhook request", slog.String("method", method))
+
+	pw := &probeWriter{header: http.Header{}}
+	s.handleWebhook(pw, req)
+
+	status := cmp.Or(pw.status, http.StatusOK)
+	class := "success"
+	if status >= http.StatusBadRequest {
+		class = "error"
+	}
+
+	body := pw.body.String()
+	if pw.truncated {
+		body += "\n… (truncated)"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	//nolint:gosec // status text and body are escaped with html.EscapeString
+	fmt.Fprintf(w, `<div class="section-label">Response <span class="%s">%d %s</span></div><pre>%s</pre>`,
+		class, status, html.EscapeString(http.StatusText(status)), html.EscapeString(body))
+}
@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_handleTest(t *testing.T) {
+	var bodies []string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `<ok>`+strings.Repeat("x", maxProbeBody)+`</ok>`)
+	}))
+	defer remote.Close()
+
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		Client: remote.Client(), Password: "pass", AllowDryRun: true}
+	h := s.routes(webFS)
+
+	token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v": "{{.value}}", "path": "{{index ._path 0}}"}`,
+		SignatureProvider: config.SignatureProviderGitHub, Signature: &config.Signature{Secret: "gh-secret"}})
+	require.NoError(t, err)
+
+	send := func(form url.Values, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("remapjson", pass)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("requires basic auth", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send(url.Values{"token": {token}}, "wrong").Code)
+		assert.Empty(t, bodies)
+	})
+
+	t.Run("sends the sample to the remote", func(t *testing.T) {
+		rec := send(url.Values{"token": {"http://localhost:8080/wh/" + token + "/orders"}, "data": {`{"value":"x"}`}}, "pass")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, bodies, 1)
+		assert.JSONEq(t, `{"v": "x", "path": "orders"}`, bodies[0])
+
+		assert.Contains(t, rec.Body.String(), `<span class="success">201 Created</span>`)
+		assert.Contains(t, rec.Body.String(), "&lt;ok&gt;xxx")
+		assert.Contains(t, rec.Body.String(), "… (truncated)")
+		assert.NotContains(t, rec.Body.String(), "&lt;/ok&gt;")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		rec := send(url.Values{"token": {token + "/orders"}, "data": {`{"value":"y"}`}, "dry_run": {"true"}}, "pass")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, bodies, 1, "dry run is not sent")
+		assert.Contains(t, rec.Body.String(), "200 OK")
+		assert.Contains(t, rec.Body.String(), "&#34;method&#34;:&#34;POST&#34;")
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		rec := send(url.Values{"token": {"invalid"}, "data": {`{}`}}, "pass")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `<span class="error">400 Bad Request</span>`)
+		assert.Contains(t, rec.Body.String(), "invalid token")
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		rec := send(url.Values{"data": {`{}`}}, "pass")
+		assert.Contains(t, rec.Body.String(), "missing token")
+	})
+}
+
+func TestServer_handleTest_disabled(t *testing.T) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}}
+	h := s.routes(webFS)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("token=x")))
+	assert.NotEqual(t, http.StatusOK, rec.Code, "test requests require the password")
+}
@@ -2,9 +2,12 @@ package rest
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/cipher"
 	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,15 +15,31 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/Semior001/remapjson/pkg/schema"
+	"github.com/Semior001/remapjson/pkg/store"
 	"github.com/cappuccinotm/slogx"
 	slogxl "github.com/cappuccinotm/slogx/logger"
 	"github.com/didip/tollbooth/v8"
+	"github.com/didip/tollbooth/v8/limiter"
+	"github.com/go-pkgz/expirable-cache/v3"
 	R "github.com/go-pkgz/rest"
 	"github.com/go-pkgz/routegroup"
 )
@@ -28,53 +47,255 @@ import (
 //go:embed web/*
 var webFS embed.FS
 
+var errBodyTooLarge = errors.New("request body too large")
+
+var defaultAllowedSchemes = []string{"http", "https"}
+
+const (
+	defaultMaxBodySize = 1 * 1024 * 1024  // 1MB
+	maxDrainSize       = 64 * 1024 * 1024 // 64MB, above that it's cheaper to drop the connection
+
+	defaultShutdownTimeout = 10 * time.Second
+	defaultMaxJSONDepth    = 64
+
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 30 * time.Second
+)
+
 // Sealer defines methods to crypt and decrypt webhook configurations,
 // allowing them to be safely included in URLs without exposing sensitive
 // information or risking tampering.
 type Sealer interface {
-	Seal(urlStr, tmplStr string) (string, error)
-	Unseal(token string) (urlStr, tmplStr string, err error)
+	Seal(cfg config.Webhook) (string, error)
+	Unseal(token string) (config.Webhook, error)
+}
+
+// currentSealer is implemented by the sealers accepting the tokens of the
+// retired secrets, to tell them from the ones sealed with the current secret.
+type currentSealer interface {
+	UnsealCurrent(token string) (config.Webhook, error)
+}
+
+// Store keeps track of configured webhooks.
+type Store interface {
+	Put(ctx context.Context, wh store.Webhook) error
+	List(ctx context.Context) ([]store.Webhook, error)
 }
 
 // Server remaps the incoming JSON to the request, as specified by the
 // configuration in the URL
 type Server struct {
-	Addr     string
-	BaseURL  string // must be without trailing slash, e.g. http://localhost:8080
-	Version  string
-	Password string //nolint:gosec // intentional secret field
+	Addr      string // host:port, or unix:/path/to.sock to listen on a Unix socket
+	BaseURL   string // must be without trailing slash, e.g. http://localhost:8080
+	Version   string
+	BuildDate string
+	Password  string //nolint:gosec // intentional secret field
+	APIKey    string //nolint:gosec // intentional secret field, alternative to the Password for automation
+
+	Client      *http.Client
+	Debug       bool
+	Sealer      Sealer
+	Store       Store   // optional, if set, configured webhooks are recorded
+	MaxBodySize int64   // maximum request body size in bytes, defaults to 1MB
+	RateLimit   float64 // maximum requests per second per client, 0 disables the limit
+
+	// LogBodies enables logging of the inbound and outbound bodies in debug
+	// mode, with the values of RedactFields masked.
+	LogBodies    bool
+	RedactFields []string
+
+	// NoRemoteLog disables the info log of the remote responses, with the
+	// remote host, status and latency of every delivery.
+	NoRemoteLog bool
+
+	// IdempotencyTTL is how long remote responses are remembered for webhooks
+	// with the idempotency header configured, 0 disables replays.
+	IdempotencyTTL time.Duration
+
+	// CoalesceWindow enables sharing of a single delivery between identical
+	// requests to the same webhook: the ones arriving while it's in flight,
+	// or within the window after it, get its response, 0 disables coalescing.
+	CoalesceWindow time.Duration
+
+	// DeliveryTTL is how long the receipts of webhook deliveries are kept
+	// to be queried by their X-Delivery-ID, 0 disables receipts.
+	DeliveryTTL time.Duration
+
+	// ReplayBodySize is the maximum size of the incoming body kept with the
+	// delivery receipt to replay the delivery, 0 disables replays. Replays
+	// are available only to the holders of the Password.
+	// EncryptReplayBodies makes the kept requests encrypted in memory.
+	ReplayBodySize      int64
+	EncryptReplayBodies bool
+
+	// ShutdownTimeout is how long to wait for in-flight requests to complete
+	// on shutdown before closing the connections, defaults to 10 seconds.
+	ShutdownTimeout time.Duration
+
+	// ShutdownDelay is how long to keep serving after the shutdown is
+	// requested, with /readyz reporting 503, for the load balancers to
+	// stop routing the traffic to the server before it stops listening.
+	ShutdownDelay time.Duration
+
+	// ReadHeaderTimeout, WriteTimeout and IdleTimeout configure the timeouts
+	// of the HTTP server, 0 means 5, 30 and 30 seconds respectively. The
+	// write timeout covers the whole delivery to the remote, so it has to
+	// exceed the outbound timeout, or the slow responses get truncated.
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// TemplateTimeout is the maximum template execution time, 0 means no limit.
+	// The cap is advisory: an aborted execution can't be interrupted and keeps
+	// running in the background until it completes.
+	TemplateTimeout time.Duration
+
+	// MaxResponseSize is the maximum size of the remote response body in
+	// bytes, 0 means no limit. The body is streamed to the caller as is,
+	// larger responses are answered with 502 Bad Gateway if the remote
+	// declares the length, otherwise the connection is closed mid-body.
+	MaxResponseSize int64
+
+	// BreakerThreshold is the number of consecutive failures of a remote
+	// host, after which its requests are rejected with 503 Service Unavailable
+	// for BreakerCooldown, 0 disables circuit breaking.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// UserAgent of the outbound requests, unless overridden per webhook,
+	// defaults to remapjson/<version>.
+	UserAgent string
+
+	// RequestIDHeader is the header of the outbound requests, which carries
+	// the ID of the incoming request, defaults to X-Request-ID, "-" disables
+	// the propagation.
+	RequestIDHeader string
+
+	// TemplateCacheSize is the maximum number of parsed templates, as well as
+	// unsealed configurations and compiled schemas, kept in memory,
+	// the least recently used are evicted first, 0 means no limit.
+	TemplateCacheSize int
+
+	// TemplateEnv is the allowlist of environment variables, which can be
+	// read in templates with the env function, empty disables the function.
+	TemplateEnv []string
+
+	// MaxTimeout caps the outbound request timeouts, both configured
+	// per webhook and the default client one, 0 means no cap.
+	MaxTimeout time.Duration
+
+	// TrustedProxies are the networks of the proxies, which are trusted
+	// to pass the client IP in X-Forwarded-For and X-Real-IP. If empty,
+	// the headers are trusted from any peer.
+	TrustedProxies []netip.Prefix
+
+	// AllowedSchemes are the URL schemes of the remotes allowed to be
+	// configured, defaults to http and https.
+	AllowedSchemes []string
 
-	Client *http.Client
-	Debug  bool
-	Sealer Sealer
+	// CORS configures cross-origin access to the web API,
+	// disabled if no origins are allowed.
+	CORS CORS
 
-	templates sync.Map // map[string]*template.Template - cache of parsed templates
+	// NoWebUI disables the web UI and its API, leaving only the webhooks
+	// and the health check.
+	NoWebUI bool
+
+	// WebDir, if set, is the directory with the web UI files, which take
+	// precedence over the embedded ones, e.g. to override theme.css.
+	WebDir string
+
+	// RoutePrefix is the path all routes are served under, e.g. /remapjson
+	// behind a path-based reverse proxy, empty serves them at the root.
+	// The webhook URLs are built from the BaseURL and the prefix.
+	RoutePrefix string
+
+	// LenientJSON makes the JSON payloads of webhooks to be decoded
+	// tolerating comments and trailing commas.
+	LenientJSON bool
+
+	// MaxJSONDepth is the maximum nesting depth of the JSON and YAML
+	// payloads of webhooks, defaults to 64.
+	MaxJSONDepth int
+
+	// AllowDryRun enables the X-RemapJSON-DryRun header on webhooks,
+	// which returns the rendered outbound request instead of sending it.
+	AllowDryRun bool
+
+	// AccessLog, if set, receives a JSON line per webhook delivery.
+	AccessLog io.Writer
+
+	tokenLimiters sync.Map // map[float64]*limiter.Limiter - per-token rate limiters, by rate
+	breakers      sync.Map // map[string]*breaker - circuit breakers, by remote host
+	breakerTrips  atomic.Int64
+
+	cachesOnce sync.Once
+	templates  cache.Cache[string, *parsedTemplate] // parsed templates by hash of their sources
+	configs    cache.Cache[string, config.Webhook]  // unsealed configurations by token
+	schemas    cache.Cache[string, *schema.Schema]  // compiled payload schemas by hash of their sources
+
+	replaysOnce sync.Once
+	replays     cache.Cache[string, replay] // remote responses by token and idempotency key
+
+	flightsMu sync.Mutex
+	flights   map[string]*flight // deliveries shared by identical requests, by coalesceKey
+
+	receiptsOnce sync.Once
+	receiptsMu   sync.Mutex                   // serializes updates of the receipts
+	receipts     cache.Cache[string, receipt] // delivery receipts by ID
+
+	replayCipherOnce sync.Once
+	replayAEAD       cipher.AEAD // encrypts the requests kept to replay deliveries
+	replayCipherErr  error
+
+	inFlight    atomic.Int64 // number of requests being handled
+	draining    atomic.Bool  // set once the shutdown is requested
+	accessLogMu sync.Mutex   // serializes writes to the access log
+
+	copyFailures struct {
+		client atomic.Int64 // failed to write the response to the caller
+		remote atomic.Int64 // failed to read the response from the remote
+	}
 }
 
 // Run starts the server and listens for incoming requests.
 // It blocks until the context is canceled.
 func (s *Server) Run(ctx context.Context) (err error) {
-	stripFS, err := fs.Sub(webFS, "web")
-	if err != nil {
+	var staticFS fs.FS
+	if staticFS, err = fs.Sub(webFS, "web"); err != nil {
 		return fmt.Errorf("strip web prefix from embedded FS: %w", err)
 	}
+	if s.WebDir != "" {
+		staticFS = overlayFS{upper: os.DirFS(s.WebDir), lower: staticFS}
+	}
+
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
 
 	srv := &http.Server{
-		Addr:              s.Addr,
-		Handler:           s.routes(stripFS),
-		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		IdleTimeout:       30 * time.Second,
+		Handler:           s.routes(staticFS),
+		ReadHeaderTimeout: cmp.Or(s.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      cmp.Or(s.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       cmp.Or(s.IdleTimeout, defaultIdleTimeout),
 	}
 
 	go func() {
 		<-ctx.Done()
+		s.draining.Store(true)
+		if s.ShutdownDelay > 0 {
+			slog.Info("draining before shutdown", slog.Duration("delay", s.ShutdownDelay))
+			time.Sleep(s.ShutdownDelay)
+		}
 		if srv != nil {
-			// graceful shutdown with 10 second timeout
-			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout())
 			defer cancel()
 			if serr := srv.Shutdown(shutdownCtx); serr != nil {
-				slog.Error("failed to gracefully shutdown http server", slogx.Error(serr))
+				slog.Error("failed to gracefully shutdown http server",
+					slog.Int64("interrupted_requests", s.inFlight.Load()),
+					slogx.Error(serr))
 				if cerr := srv.Close(); cerr != nil {
 					slog.Error("failed to forcefully close http server", slogx.Error(cerr))
 				}
@@ -85,47 +306,145 @@ func (s *Server) Run(ctx context.Context) (err error) {
 	slog.Info("starting server",
 		slog.String("addr", s.Addr),
 		slog.String("base_url", s.BaseURL),
-		slog.Bool("password", s.Password != ""))
+		slog.String("route_prefix", s.routePrefix()),
+		slog.Bool("password", s.Password != ""),
+		slog.Bool("api_key", s.APIKey != ""),
+		slog.Int64("max_body_size", s.maxBodySize()),
+		slog.Int64("max_response_size", s.MaxResponseSize),
+		slog.Int("breaker_threshold", s.BreakerThreshold),
+		slog.Float64("rate_limit", s.RateLimit),
+		slog.Int("trusted_proxies", len(s.TrustedProxies)),
+		slog.Duration("idempotency_ttl", s.IdempotencyTTL),
+		slog.Duration("delivery_ttl", s.DeliveryTTL),
+		slog.Duration("coalesce_window", s.CoalesceWindow),
+		slog.Int64("replay_body_size", s.ReplayBodySize),
+		slog.Bool("encrypt_replay_bodies", s.EncryptReplayBodies),
+		slog.Duration("shutdown_timeout", s.shutdownTimeout()),
+		slog.Duration("shutdown_delay", s.ShutdownDelay),
+		slog.Duration("write_timeout", cmp.Or(s.WriteTimeout, defaultWriteTimeout)),
+		slog.Duration("template_timeout", s.TemplateTimeout),
+		slog.Int("template_cache_size", s.TemplateCacheSize),
+		slog.Bool("allow_dry_run", s.AllowDryRun),
+		slog.Bool("lenient_json", s.LenientJSON),
+		slog.Bool("web_ui", !s.NoWebUI),
+		slog.String("web_dir", s.WebDir))
 
 	defer func() { slog.WarnContext(ctx, "server stopped", slogx.Error(err)) }()
 
-	if err = srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		return fmt.Errorf("listen and serve: %w", err)
+	if err = srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
 	}
 
 	return nil
 }
 
+// listen opens the listener on the configured address, a Unix socket if
+// the address starts with "unix:", TCP otherwise. The socket file left by
+// a crashed instance is removed before listening, and the socket file
+// itself is removed once the listener is closed.
+func (s *Server) listen() (net.Listener, error) {
+	path, ok := strings.CutPrefix(s.Addr, "unix:")
+	if !ok {
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen on %s: %w", s.Addr, err)
+		}
+		return ln, nil
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket %s: %w", path, err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on unix socket %s: %w", path, err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	return ln, nil
+}
+
 func (s *Server) routes(staticFS fs.FS) http.Handler {
-	rtr := routegroup.New(http.NewServeMux())
+	prefix := s.routePrefix()
+	rtr := routegroup.Mount(http.NewServeMux(), prefix)
 
 	logger := slogxl.New()
 
 	rtr.Use(
+		s.trackInFlight,
 		AssignRequestID,
-		R.RealIP,
+		s.realIP,
 		Recoverer,
-		R.Throttle(1000),
+		s.throttle(1000),
 		R.AppInfo("remapjson", "semior", s.Version),
 		R.Ping,
-		R.SizeLimit(1*1024*1024), // 1MB max body size
-		tollbooth.HTTPMiddleware(tollbooth.NewLimiter(10, nil)), // 10 req/s global rate limit
+		s.sizeLimit,
+		R.Maybe(s.rateLimit(s.RateLimit), func(*http.Request) bool { return s.RateLimit > 0 }),
 		R.Maybe(logger.HTTPServerMiddleware, func(*http.Request) bool { return s.Debug }),
 	)
 
-	rtr.HandleFunc("/wh/{token}", s.handleWebhook)
+	rtr.HandleFunc("/wh/{token}", s.deliveryReceipts(s.accessLog(s.handleWebhook)))
+	rtr.HandleFunc("/wh/{token}/{rest...}", s.deliveryReceipts(s.accessLog(s.handleWebhook)))
+	rtr.HandleFunc("GET /health", s.handleHealth)
+	rtr.HandleFunc("GET /livez", s.handleLivez)
+	rtr.HandleFunc("GET /readyz", s.handleReadyz)
+	rtr.HandleFunc("GET /version", s.handleVersion)
+	if s.DeliveryTTL > 0 {
+		rtr.HandleFunc("GET /deliveries/{id}", s.handleDelivery)
+		if s.ReplayBodySize > 0 && (s.Password != "" || s.APIKey != "") {
+			rtr.With(s.auth).HandleFunc("POST /deliveries/{id}/replay", s.handleReplay)
+		}
+	}
+
+	if s.NoWebUI {
+		return rtr
+	}
+
+	rtr.Handle("GET /{$}", http.RedirectHandler(prefix+"/web/", http.StatusFound))
 
 	rtr.Group().Route(func(webapi *routegroup.Bundle) {
 		webapi.Use(
-			R.Maybe(R.BasicAuthWithPrompt("remapjson", s.Password), func(_ *http.Request) bool { return s.Password != "" }),
+			R.Maybe(s.CORS.Handler, func(*http.Request) bool { return len(s.CORS.Origins) > 0 }),
+			s.auth,
 			logger.HTTPServerMiddleware,
 		)
 
-		webapi.Handle("GET /web/", http.StripPrefix("/web/", http.FileServer(http.FS(staticFS))))
+		// registered as a func, as routegroup doesn't prefix the methods with
+		// the base path right for the patterns ending with a slash
+		webapi.HandleFunc("GET /web/", http.StripPrefix(prefix+"/web/", http.FileServer(http.FS(staticFS))).ServeHTTP)
 
 		webapi.HandleFunc("POST /configure", s.handleConfigure)
+		webapi.HandleFunc("POST /configure/bulk", s.handleConfigureBulk)
 		webapi.HandleFunc("POST /render", s.handleRender)
+		webapi.HandleFunc("POST /lint", s.handleLint)
 		webapi.HandleFunc("POST /unseal", s.handleUnseal)
+		webapi.HandleFunc("POST /reseal", s.handleReseal)
+		webapi.HandleFunc("POST /validate", s.handleValidate)
+		webapi.HandleFunc("POST /preview", s.handlePreview)
+		if s.Password != "" || s.APIKey != "" {
+			// test requests skip the signature verification, so only the operator may send them
+			webapi.HandleFunc("POST /test", s.handleTest)
+		}
+
+		webapi.HandleFunc("GET /admin/cache", s.handleCacheStats)
+		webapi.HandleFunc("DELETE /admin/cache", s.handleCacheClear)
+
+		if s.Store != nil {
+			webapi.HandleFunc("GET /configure", s.handleList)
+			webapi.HandleFunc("GET /admin/export", s.handleExport)
+			webapi.HandleFunc("POST /admin/import", s.handleImport)
+		}
+
+		if len(s.CORS.Origins) > 0 {
+			// preflight requests are answered by the CORS middleware
+			for _, path := range []string{"/web/", "/configure", "/configure/bulk", "/render", "/lint", "/unseal", "/reseal", "/validate", "/preview", "/test", "/admin/"} {
+				webapi.HandleFunc("OPTIONS "+path, func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				})
+			}
+		}
 	})
 
 	return rtr
@@ -133,48 +452,62 @@ func (s *Server) routes(staticFS fs.FS) http.Handler {
 
 // POST /configure - encode the provided URL and template, effectively preparing
 // the webhook URL for future requests.
+// Accepts either the form values of the web UI or the JSON configuration,
+// see webhookFromJSON.
 // This endpoint can be used to pre-cache templates or validate them before use.
 func (s *Server) handleConfigure(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	if err := r.ParseForm(); err != nil {
-		s.error(w, r, http.StatusBadRequest, "invalid form data: %v", err)
-		return
-	}
-	urlStr, tmplStr := r.FormValue("url"), r.FormValue("template")
-
-	if urlStr == "" || tmplStr == "" {
-		s.error(w, r, http.StatusBadRequest, "missing URL or template")
-		return
+	var (
+		cfg    config.Webhook
+		sample string
+		err    error
+	)
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		if cfg, sample, err = webhookFromJSON(r); err != nil {
+			s.error(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body: %v", err)
+			return
+		}
+	} else {
+		if err = r.ParseForm(); err != nil {
+			s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
+			return
+		}
+		if cfg, err = webhookFromForm(r); err != nil {
+			s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
+			return
+		}
+		sample = strings.TrimSpace(r.FormValue("data"))
 	}
 
-	// precompile template
-	if _, err := s.template(urlStr, tmplStr); err != nil {
-		s.error(w, r, http.StatusBadRequest, "invalid template: %v", err)
+	if err = s.checkWebhook(cfg); err != nil {
+		s.fail(w, r, err)
 		return
 	}
 
-	token, err := s.Sealer.Seal(urlStr, tmplStr)
+	token, err := s.sealWebhook(ctx, cfg)
 	if err != nil {
-		s.error(w, r, http.StatusInternalServerError, "failed to seal configuration: %v", err)
+		s.fail(w, r, err)
 		return
 	}
-	webhookURL := s.BaseURL + "/wh/" + token
+	webhookURL := s.webhookURL(token)
+	curl := curlCommand(webhookURL, cmp.Or(sample, "{}"))
 
 	if r.Header.Get("HX-Request") == "true" {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		escaped := html.EscapeString(webhookURL)
-		//nolint:gosec // webhookURL is escaped with html.EscapeString above
-		fmt.Fprintf(w, `<input type="text" readonly value="%s">`+
-			`<button class="btn-copy" onclick="navigator.clipboard.writeText(this.previousElementSibling.value)">Copy</button>`,
-			escaped)
+		const copyBtn = `<button class="btn-copy" onclick="navigator.clipboard.writeText(this.previousElementSibling.value)">Copy</button>`
+		//nolint:gosec // webhookURL and curl are escaped with html.EscapeString
+		fmt.Fprintf(w, `<input type="text" readonly value="%s">`+copyBtn+`<input type="text" readonly value="%s">`+copyBtn,
+			html.EscapeString(webhookURL), html.EscapeString(curl))
 		return
 	}
 
 	var resp struct {
 		WebhookURL string `json:"webhook_url"`
+		Curl       string `json:"curl"`
 	}
 	resp.WebhookURL = webhookURL
+	resp.Curl = curl
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -183,8 +516,50 @@ func (s *Server) handleConfigure(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkWebhook validates the webhook configuration and precompiles its
+// templates, the errors carry the status and the code to respond with.
+func (s *Server) checkWebhook(cfg config.Webhook) error {
+	if err := cfg.Validate(); err != nil {
+		return withStatus(http.StatusBadRequest, codeInvalidConfig, "%w", err)
+	}
+
+	if cfg.URL != "" { // raw requests are checked once rendered, local responses have no remote
+		if err := s.checkURL(cfg.URL); err != nil {
+			return withStatus(http.StatusBadRequest, codeInvalidConfig, "%w", err)
+		}
+	}
+
+	// precompile template
+	if _, err := s.template(cfg); err != nil {
+		return withStatus(http.StatusBadRequest, codeTemplateError, "invalid template: %w", err)
+	}
+	if cfg.Condition != "" {
+		if _, err := s.template(config.Webhook{URL: cfg.URL, Tmpl: cfg.Condition, Delims: cfg.Delims}); err != nil {
+			return withStatus(http.StatusBadRequest, codeTemplateError, "invalid condition: %w", err)
+		}
+	}
+	return nil
+}
+
+// sealWebhook seals the checked webhook configuration into the token and
+// records it in the store, if any.
+func (s *Server) sealWebhook(ctx context.Context, cfg config.Webhook) (string, error) {
+	token, err := s.Sealer.Seal(cfg)
+	if err != nil {
+		return "", withStatus(http.StatusInternalServerError, codeInternal, "failed to seal configuration: %w", err)
+	}
+
+	if s.Store != nil {
+		wh := store.Webhook{ID: config.Fingerprint(token), Token: token, CreatedAt: time.Now()}
+		if err = s.Store.Put(ctx, wh); err != nil {
+			return "", withStatus(http.StatusInternalServerError, codeInternal, "failed to store webhook: %w", err)
+		}
+	}
+	return token, nil
+}
+
 // POST /render - renders a Go template with example JSON data and returns an HTML preview.
-// Accepts application/x-www-form-urlencoded with fields: template, data.
+// Accepts application/x-www-form-urlencoded with fields: template, data, include_fields.
 func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		//nolint:gosec // error message is escaped with html.EscapeString
@@ -193,13 +568,22 @@ func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tmplStr := r.FormValue("template")
+	delims := delimsFromForm(r)
 	dataStr := r.FormValue("data")
 
-	if tmplStr == "" {
+	partials, err := partialsFromForm(r)
+	if err != nil {
+		//nolint:gosec // error message is escaped with html.EscapeString
+		fmt.Fprintf(w, `<span class="error">partials: %s</span>`, html.EscapeString(err.Error()))
 		return
 	}
+	includeFields := splitList(r.FormValue("include_fields"))
 
-	var data map[string]any
+	if tmplStr == "" && len(includeFields) == 0 {
+		return
+	}
+
+	var data any
 	if dataStr != "" {
 		if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
 			//nolint:gosec // error message is escaped with html.EscapeString
@@ -208,46 +592,191 @@ func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tmpl, err := template.New("").Parse(tmplStr)
+	if len(includeFields) > 0 {
+		var err error
+		if data, err = project(data, includeFields); err != nil {
+			//nolint:gosec // error message is escaped with html.EscapeString
+			fmt.Fprintf(w, `<span class="error">include fields: %s</span>`, html.EscapeString(err.Error()))
+			return
+		}
+	}
+
+	if tmplStr == "" {
+		b, _ := json.Marshal(data) // data is decoded from JSON, so it's always encodable
+		writePreview(w, r, b)
+		return
+	}
+
+	in := inbound{raw: []byte(dataStr), nonce: newNonce(), disclosed: true}
+	preview := config.Webhook{Tmpl: tmplStr, Delims: delims, Partials: partials}
+	tmpl, err := s.parseTemplate(preview)
+	if err == nil {
+		tmpl, err = bindRequest(tmpl, preview, in)
+	}
 	if err != nil {
 		//nolint:gosec // error message is escaped with html.EscapeString
 		fmt.Fprintf(w, `<span class="error">template: %s</span>`, html.EscapeString(err.Error()))
 		return
 	}
 
-	buf := &bytes.Buffer{}
-	if err = tmpl.Execute(buf, data); err != nil {
+	in.consts, err = constants(json.RawMessage(strings.TrimSpace(r.FormValue("constants"))))
+	if err != nil {
+		//nolint:gosec // error message is escaped with html.EscapeString
+		fmt.Fprintf(w, `<span class="error">constants: %s</span>`, html.EscapeString(err.Error()))
+		return
+	}
+
+	data = withInbound(data, in)
+	var limit int64
+	if r.FormValue("double_pass") == "true" {
+		limit = s.maxBodySize()
+	}
+	out, err := s.execute(tmpl, data, limit)
+	if err == nil && limit > 0 {
+		out, err = s.executeRendered(preview, out, data)
+	}
+	if err != nil {
+		if kind := jsonKind(data); kind != "object" && kind != "null" && fieldAccessError(err) {
+			err = fmt.Errorf("example data is %s, but template expects an object: %w", kind, err)
+		}
 		//nolint:gosec // error message is escaped with html.EscapeString
 		fmt.Fprintf(w, `<span class="error">render: %s</span>`, html.EscapeString(err.Error()))
 		return
 	}
 
+	writePreview(w, r, out)
+}
+
+// writePreview writes the rendered output as an HTML fragment. Unless the raw
+// output is requested, valid JSON is re-indented and highlighted, and invalid
+// one is marked with a warning, if the outbound request is expected to be JSON.
+func writePreview(w http.ResponseWriter, r *http.Request, out []byte) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	//nolint:gosec // buf content is escaped with html.EscapeString
-	fmt.Fprintf(w, `<pre>%s</pre>`, html.EscapeString(buf.String()))
+
+	raw := r.FormValue("raw_output") == "true"
+	if !raw && json.Valid(out) {
+		buf := &bytes.Buffer{}
+		_ = json.Indent(buf, out, "", "  ") // valid JSON can always be indented
+		//nolint:gosec // tokens are escaped with html.EscapeString
+		fmt.Fprintf(w, `<pre class="json">%s</pre>`, highlightJSON(buf.Bytes()))
+		return
+	}
+
+	if !raw && expectsJSON(r) {
+		fmt.Fprint(w, `<span class="warning">output is not valid JSON</span>`)
+	}
+	//nolint:gosec // out is escaped with html.EscapeString
+	fmt.Fprintf(w, `<pre>%s</pre>`, html.EscapeString(string(out)))
+}
+
+// expectsJSON reports whether the outbound body of the configuration
+// in the form is expected to be JSON.
+func expectsJSON(r *http.Request) bool {
+	if r.FormValue("base64_body") == "true" || r.FormValue("raw_request") == "true" {
+		return false
+	}
+	if r.FormValue("yaml_body") == "true" { // converted from JSON
+		return true
+	}
+	ct := r.FormValue("content_type")
+	return ct == "" || strings.Contains(strings.ToLower(ct), "json")
+}
+
+// highlightJSON wraps the tokens of the valid JSON into spans of classes
+// json-key, json-string, json-number and json-literal, escaping them.
+func highlightJSON(b []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(b); {
+		start := i
+		class := ""
+		switch c := b[i]; {
+		case c == '"':
+			for i++; i < len(b) && b[i] != '"'; i++ {
+				if b[i] == '\\' {
+					i++ // skip the escaped character
+				}
+			}
+			i++
+			class = "json-string"
+			if rest := bytes.TrimLeft(b[i:], " \t\r\n"); len(rest) > 0 && rest[0] == ':' {
+				class = "json-key"
+			}
+		case c == '-' || (c >= '0' && c <= '9'):
+			for i < len(b) && strings.IndexByte("+-.0123456789eE", b[i]) >= 0 {
+				i++
+			}
+			class = "json-number"
+		case c >= 'a' && c <= 'z': // true, false, null
+			for i < len(b) && b[i] >= 'a' && b[i] <= 'z' {
+				i++
+			}
+			class = "json-literal"
+		default:
+			i++
+		}
+
+		tok := html.EscapeString(string(b[start:i]))
+		if class == "" {
+			sb.WriteString(tok)
+			continue
+		}
+		fmt.Fprintf(&sb, `<span class="%s">%s</span>`, class, tok)
+	}
+	return sb.String()
 }
 
 // POST /unseal - decodes a token (or full webhook URL) and returns the target URL and template.
 // Accepts application/x-www-form-urlencoded with field: token.
+// Responds with an HTML fragment to HTMX requests and with JSON otherwise.
 func (s *Server) handleUnseal(w http.ResponseWriter, r *http.Request) {
+	if !wantsJSON(r) {
+		s.handleUnsealHTML(w, r)
+		return
+	}
+
+	ctx := r.Context()
+
 	if err := r.ParseForm(); err != nil {
-		//nolint:gosec // error message is escaped with html.EscapeString
-		fmt.Fprintf(w, `<span class="error">invalid form: %s</span>`, html.EscapeString(err.Error()))
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
 		return
 	}
 
 	raw := r.FormValue("token")
 	if raw == "" {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "missing token")
 		return
 	}
 
-	// accept either a full webhook URL or just the bare token
-	token := raw
-	if idx := strings.LastIndex(raw, "/wh/"); idx != -1 {
-		token = raw[idx+len("/wh/"):]
+	cfg, err := s.Sealer.Unseal(tokenFromInput(raw))
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidToken, "invalid token: %v", err)
+		return
+	}
+
+	resp := struct {
+		URL      string `json:"url"`
+		Template string `json:"template"`
+	}{URL: cfg.URL, Template: cfg.Tmpl}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+func (s *Server) handleUnsealHTML(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		//nolint:gosec // error message is escaped with html.EscapeString
+		fmt.Fprintf(w, `<span class="error">invalid form: %s</span>`, html.EscapeString(err.Error()))
+		return
+	}
+
+	raw := r.FormValue("token")
+	if raw == "" {
+		return
 	}
 
-	urlStr, tmplStr, err := s.Sealer.Unseal(token)
+	cfg, err := s.Sealer.Unseal(tokenFromInput(raw))
 	if err != nil {
 		//nolint:gosec // error message is escaped with html.EscapeString
 		fmt.Fprintf(w, `<span class="error">%s</span>`, html.EscapeString(err.Error()))
@@ -261,101 +790,870 @@ func (s *Server) handleUnseal(w http.ResponseWriter, r *http.Request) {
 			`<div class="preview-box"><pre>%s</pre></div></div>`+
 			`<div class="field"><div class="section-label">Template</div>`+
 			`<div class="preview-box"><pre>%s</pre></div></div>`,
-		html.EscapeString(urlStr), html.EscapeString(tmplStr))
+		html.EscapeString(cfg.URL), html.EscapeString(cfg.Tmpl))
 }
 
-// ANY /wh/<base64url-encoded-aes-gcm-sealed-config>
-// sends a request to the remote server, remapping the incoming JSON to
-// the request, as specified by the sealed configuration token in the URL.
-func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+// POST /reseal - unseals a token (or full webhook URL), sealed with the current
+// or a retired secret, and seals its configuration again with the current secret.
+// Accepts application/x-www-form-urlencoded with field: token.
+// Responds with the new webhook URL, the old one keeps working until its
+// secret is dropped from the retired ones.
+func (s *Server) handleReseal(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	remoteURL, rawTmpl, err := s.Sealer.Unseal(r.PathValue("token"))
-	if err != nil {
-		s.error(w, r, http.StatusBadRequest, "invalid token: %v", err)
+	if err := r.ParseForm(); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
 		return
 	}
 
-	//nolint:gosec // remoteURL and rawTmpl come from operator-sealed token, log injection is accepted
-	slog.Info("handling request",
-		slog.String("remote_url", remoteURL),
-		slog.String("template", rawTmpl))
+	raw := r.FormValue("token")
+	if raw == "" {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "missing token")
+		return
+	}
 
-	body, err := io.ReadAll(r.Body)
+	cfg, err := s.Sealer.Unseal(tokenFromInput(raw))
 	if err != nil {
-		s.error(w, r, http.StatusBadRequest, "failed to read request body: %v", err)
+		s.error(w, r, http.StatusBadRequest, codeInvalidToken, "invalid token: %v", err)
 		return
 	}
 
-	var data map[string]any
-	if len(body) > 0 {
-		if err = json.Unmarshal(body, &data); err != nil {
-			s.error(w, r, http.StatusBadRequest, "invalid JSON: %v", err)
-			return
+	token, err := s.sealWebhook(ctx, cfg)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	resp := struct {
+		WebhookURL string `json:"webhook_url"`
+	}{WebhookURL: s.webhookURL(token)}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// POST /validate - checks whether the token (or full webhook URL) is accepted
+// by the server, e.g. to audit the live tokens during the secret rotation.
+// Accepts application/x-www-form-urlencoded with field: token.
+// Responds with the token fingerprint, its validity, whether it's sealed
+// with the current secret, and the host of the target URL, never with the
+// rest of the configuration.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
+		return
+	}
+
+	raw := r.FormValue("token")
+	if raw == "" {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "missing token")
+		return
+	}
+	token := tokenFromInput(raw)
+
+	resp := struct {
+		ID      string `json:"id"`
+		Valid   bool   `json:"valid"`
+		Current bool   `json:"current"`
+		URLHost string `json:"url_host,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}{ID: config.Fingerprint(token)}
+
+	cfg, err := s.Sealer.Unseal(token)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Valid = true
+		resp.Current = true
+		if cs, ok := s.Sealer.(currentSealer); ok {
+			_, cerr := cs.UnsealCurrent(token)
+			resp.Current = cerr == nil
+		}
+		if u, perr := url.Parse(cfg.URL); perr == nil {
+			resp.URLHost = u.Host
 		}
 	}
 
-	tmpl, err := s.template(remoteURL, rawTmpl)
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// POST /preview - renders the outbound request for the token (or full webhook URL)
+// and the sample payload, without sending it.
+// Accepts application/x-www-form-urlencoded with fields: token, data, method (defaults to POST).
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid form data: %v", err)
+		return
+	}
+
+	raw := r.FormValue("token")
+	if raw == "" {
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "missing token")
+		return
+	}
+
+	token, path := webhookFromInput(raw)
+	cfg, err := s.Sealer.Unseal(token)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidToken, "invalid token: %v", err)
+		return
+	}
+
+	sample := []byte(r.FormValue("data"))
+	if s.LenientJSON {
+		sample = relaxJSON(sample)
+	}
+	data, err := decodeJSON(sample)
 	if err != nil {
-		s.error(w, r, http.StatusBadRequest, "invalid template: %v", err)
+		s.error(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON: %v", err)
+		return
+	}
+
+	method := cmp.Or(strings.ToUpper(r.FormValue("method")), http.MethodPost)
+
+	in := inbound{raw: sample, path: path, nonce: newNonce(), disclosed: true}
+	if in.consts, err = constants(cfg.Constants); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidConfig, "invalid constants: %v", err)
 		return
 	}
 
-	buf := &bytes.Buffer{}
-	if err = tmpl.Execute(buf, data); err != nil {
-		s.error(w, r, http.StatusInternalServerError, "failed to execute template: %v", err)
+	forward, err := s.condition(cfg, data, in)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if !forward { // the webhook would respond the same, without calling the remote
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	req, err := http.NewRequestWithContext(ctx, r.Method, remoteURL, buf)
+	rendered, err := s.render(cfg, data, in)
 	if err != nil {
-		s.error(w, r, http.StatusInternalServerError, "failed to create request: %v", err)
+		s.fail(w, r, err)
 		return
 	}
 
-	//nolint:gosec // remoteURL comes from operator-sealed token, SSRF is accepted by design
-	resp, err := s.Client.Do(req)
+	req, err := s.outbound(ctx, cfg, method, data, in, rendered)
 	if err != nil {
-		s.error(w, r, http.StatusInternalServerError, "failed to send request: %v", err)
+		s.fail(w, r, err)
 		return
 	}
-	defer resp.Body.Close()
 
-	w.WriteHeader(resp.StatusCode)
-	if _, err = io.Copy(w, resp.Body); err != nil {
-		slog.WarnContext(ctx, "failed to copy response body", slogx.Error(err))
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(describeRequest(req)); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
+}
+
+// requestDescription describes the outbound request.
+type requestDescription struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// describeRequest describes the outbound request with the credentials
+// redacted: the caller knows the webhook URL, but not the sealed secrets.
+func describeRequest(req *http.Request) requestDescription {
+	desc := requestDescription{Method: req.Method, URL: req.URL.Redacted(), Headers: redactHeaders(req.Header)}
+	if req.GetBody == nil {
+		return desc
+	}
+	// the body is not consumed, a fresh copy is read from the request
+	if body, err := req.GetBody(); err == nil {
+		b, _ := io.ReadAll(body)
+		desc.Body = string(b)
+	}
+	return desc
+}
+
+// sensitiveHeaders are the headers, which values are redacted in the
+// descriptions of the outbound requests.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-API-Key"}
+
+// redactHeaders returns a copy of the headers with the values of the
+// sensitive ones masked.
+func redactHeaders(h http.Header) http.Header {
+	res := h.Clone()
+	for _, name := range sensitiveHeaders {
+		if res.Get(name) != "" {
+			res.Set(name, "[REDACTED]")
+		}
+	}
+	return res
+}
+
+// GET /version - reports the version and the build date of the server,
+// and the version of Go it is built with.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Version   string `json:"version"`
+		BuildDate string `json:"build_date"`
+		Go        string `json:"go"`
+	}{Version: s.Version, BuildDate: s.BuildDate, Go: runtime.Version()}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(r.Context(), "failed to write response", slogx.Error(err))
+	}
+}
+
+// GET /livez - reports that the process is up, always with 200.
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	s.writeProbe(w, r, http.StatusOK, "ok")
+}
+
+// GET /readyz - reports whether the server is ready to serve, responds
+// with 503 once the shutdown is requested, for the load balancers to drain
+// the traffic before the server stops.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		s.writeProbe(w, r, http.StatusServiceUnavailable, "shutting down")
 		return
 	}
+	s.writeProbe(w, r, http.StatusOK, "ok")
+}
+
+// writeProbe writes the response of the liveness and readiness probes.
+func (s *Server) writeProbe(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": msg}); err != nil {
+		slog.WarnContext(r.Context(), "failed to write response", slogx.Error(err))
+	}
+}
+
+// GET /health - reports liveness of the server. With ?token=<token or webhook URL>,
+// also checks reachability of the webhook's remote with a HEAD request
+// and responds with 503 if the remote is unreachable.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	type remoteStatus struct {
+		Host      string `json:"host"`
+		Reachable bool   `json:"reachable"`
+		Status    int    `json:"status,omitempty"`
+		LatencyMS int64  `json:"latency_ms"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	var resp struct {
+		Status string        `json:"status"`
+		Remote *remoteStatus `json:"remote,omitempty"`
+	}
+	resp.Status = "ok"
+
+	status := http.StatusOK
+	if raw := r.URL.Query().Get("token"); raw != "" {
+		cfg, err := s.Sealer.Unseal(tokenFromInput(raw))
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, codeInvalidToken, "invalid token: %v", err)
+			return
+		}
+
+		if cfg.URL == "" {
+			s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "webhook has no static remote URL to check")
+			return
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, cfg.URL, http.NoBody)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, codeInvalidConfig, "invalid remote URL: %v", err)
+			return
+		}
+		req.Header.Set("User-Agent", s.userAgent(cfg))
+
+		rs := &remoteStatus{Host: req.URL.Host}
+		start := time.Now()
+		//nolint:gosec // cfg.URL comes from operator-sealed token, SSRF is accepted by design
+		remoteResp, err := s.Client.Do(req)
+		rs.LatencyMS = time.Since(start).Milliseconds()
+		if err != nil {
+			rs.Error = err.Error()
+			resp.Status = "remote unreachable"
+			status = http.StatusServiceUnavailable
+		} else {
+			_ = remoteResp.Body.Close()
+			rs.Reachable = true
+			rs.Status = remoteResp.StatusCode
+		}
+		resp.Remote = rs
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+	}
 }
 
-func (s *Server) template(url, tstr string) (*template.Template, error) {
+func (s *Server) template(cfg config.Webhook) (*template.Template, error) {
+	parsed, err := s.parsed(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.tmpl, nil
+}
+
+// parsed returns the parsed template of the webhook configuration, cached
+// by the hash of its sources.
+func (s *Server) parsed(cfg config.Webhook) (*parsedTemplate, error) {
 	h := sha256.New()
-	_, _ = h.Write([]byte(url))
-	_, _ = h.Write([]byte(tstr))
-	key := fmt.Sprintf("%x", h.Sum(nil))
+	buf := make([]byte, 0, 20)
+	write := func(part string) {
+		// length-prefix parts, so that the boundaries between them are unambiguous
+		buf = append(strconv.AppendInt(buf[:0], int64(len(part)), 10), ':')
+		_, _ = h.Write(buf)
+		_, _ = io.WriteString(h, part)
+	}
+	write(cfg.URL)
+	write(cfg.Tmpl)
+	write(cfg.Delims[0])
+	write(cfg.Delims[1])
+	if len(cfg.Partials) > 0 {
+		for _, name := range slices.Sorted(maps.Keys(cfg.Partials)) {
+			write(name)
+			write(cfg.Partials[name])
+		}
+	}
+	key := hex.EncodeToString(h.Sum(nil))
 
-	if tmpl, ok := s.templates.Load(key); ok {
-		return tmpl.(*template.Template), nil
+	if parsed, ok := s.templateCache().Get(key); ok {
+		return parsed, nil
 	}
 
-	tmpl, err := template.New("").Parse(tstr)
+	tmpl, err := s.parseTemplate(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("parse template: %w", err)
 	}
 
-	s.templates.Store(key, tmpl)
+	parsed := &parsedTemplate{tmpl: tmpl, readsHeaders: templateReadsHeaders(tmpl)}
+	s.templateCache().Add(key, parsed)
+	return parsed, nil
+}
+
+// parsedTemplate is the cached parsed template, along with the facts
+// about it, worked out once it's parsed.
+type parsedTemplate struct {
+	tmpl         *template.Template
+	readsHeaders bool // whether it may read the incoming headers
+}
+
+func (s *Server) templateCache() cache.Cache[string, *parsedTemplate] {
+	s.initCaches()
+	return s.templates
+}
+
+// initCaches initializes the caches of the parsed templates, unsealed
+// configurations and compiled schemas, bounded by the same size.
+func (s *Server) initCaches() {
+	s.cachesOnce.Do(func() {
+		s.templates = cache.NewCache[string, *parsedTemplate]().WithLRU().WithMaxKeys(s.TemplateCacheSize)
+		s.configs = cache.NewCache[string, config.Webhook]().WithLRU().WithMaxKeys(s.TemplateCacheSize)
+		s.schemas = cache.NewCache[string, *schema.Schema]().WithLRU().WithMaxKeys(s.TemplateCacheSize)
+	})
+}
+
+// unseal returns the configuration of the webhook token. The tokens are
+// immutable, so the configurations of the recently used ones are kept,
+// sparing the decryption and decoding on every request.
+func (s *Server) unseal(token string) (config.Webhook, error) {
+	s.initCaches()
+	if cfg, ok := s.configs.Get(token); ok {
+		return cfg, nil
+	}
+
+	cfg, err := s.Sealer.Unseal(token)
+	if err != nil {
+		return config.Webhook{}, err
+	}
+
+	s.configs.Add(token, cfg)
+	return cfg, nil
+}
+
+// schema returns the compiled payload schema of the webhook configuration,
+// nil if the configuration has no schema.
+func (s *Server) schema(cfg config.Webhook) (*schema.Schema, error) {
+	if len(cfg.Schema) == 0 {
+		return nil, nil
+	}
+
+	s.initCaches()
+	key := fmt.Sprintf("%x", sha256.Sum256(cfg.Schema))
+	if sch, ok := s.schemas.Get(key); ok {
+		return sch, nil
+	}
+
+	sch, err := schema.Compile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("compile schema: %w", err)
+	}
+
+	s.schemas.Add(key, sch)
+	return sch, nil
+}
+
+// parseTemplate parses the template of the webhook configuration with its
+// delimiters, partials and the built-in functions, empty delimiters fall
+// back to the defaults. Partials are associated with the main template,
+// so that they can be invoked with {{template "name" .}}.
+func (s *Server) parseTemplate(cfg config.Webhook) (*template.Template, error) {
+	tmpl, err := template.New("").Delims(cfg.Delims[0], cfg.Delims[1]).Funcs(s.funcMap()).Parse(cfg.Tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Partials)) {
+		if _, err = tmpl.New(name).Parse(cfg.Partials[name]); err != nil {
+			return nil, fmt.Errorf("partial %q: %w", name, err)
+		}
+	}
+
 	return tmpl, nil
 }
 
-func (s *Server) error(w http.ResponseWriter, r *http.Request, status int, format string, args ...any) {
+// limiter makes a rate limiter with the given rate, the counters of the
+// keys are dropped after an hour of inactivity.
+func (s *Server) limiter(rps float64) *limiter.Limiter {
+	return tollbooth.NewLimiter(rps, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
+}
+
+// tokenLimiter returns the shared limiter for tokens with the given rate.
+func (s *Server) tokenLimiter(rps float64) *limiter.Limiter {
+	if lmt, ok := s.tokenLimiters.Load(rps); ok {
+		return lmt.(*limiter.Limiter)
+	}
+	lmt, _ := s.tokenLimiters.LoadOrStore(rps, s.limiter(rps))
+	return lmt.(*limiter.Limiter)
+}
+
+// retryAfter returns the value of Retry-After header for the given rate.
+func retryAfter(rps float64) string {
+	return strconv.Itoa(max(1, int(math.Ceil(1/rps))))
+}
+
+// checkURL checks that the remote URL is absolute and its scheme is allowed.
+func (s *Server) checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	schemes := s.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaultAllowedSchemes
+	}
+	if !slices.ContainsFunc(schemes, func(scheme string) bool { return strings.EqualFold(scheme, u.Scheme) }) {
+		return fmt.Errorf("URL scheme %q is not allowed, allowed schemes: %s", u.Scheme, strings.Join(schemes, ", "))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", raw)
+	}
+
+	return nil
+}
+
+// requestIDHeader returns the header of the outbound requests with the ID of
+// the incoming request, empty if the ID is not propagated.
+func (s *Server) requestIDHeader() string {
+	if s.RequestIDHeader == "-" {
+		return ""
+	}
+	return cmp.Or(s.RequestIDHeader, "X-Request-ID")
+}
+
+// routePrefix returns the path all routes are served under, with a leading
+// and without a trailing slash, or empty if they are served at the root.
+func (s *Server) routePrefix() string {
+	if p := strings.Trim(s.RoutePrefix, "/"); p != "" {
+		return "/" + p
+	}
+	return ""
+}
+
+// webhookURL returns the URL of the webhook with the token.
+func (s *Server) webhookURL(token string) string {
+	return s.BaseURL + s.routePrefix() + "/wh/" + token
+}
+
+// userAgent returns the User-Agent of the outbound requests of the webhook.
+func (s *Server) userAgent(cfg config.Webhook) string {
+	return cmp.Or(cfg.UserAgent, s.UserAgent, "remapjson/"+s.Version)
+}
+
+func (s *Server) shutdownTimeout() time.Duration {
+	if s.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return s.ShutdownTimeout
+}
+
+func (s *Server) maxJSONDepth() int {
+	if s.MaxJSONDepth <= 0 {
+		return defaultMaxJSONDepth
+	}
+	return s.MaxJSONDepth
+}
+
+func (s *Server) maxBodySize() int64 {
+	if s.MaxBodySize <= 0 {
+		return defaultMaxBodySize
+	}
+	return s.MaxBodySize
+}
+
+// webhookFromForm builds the webhook configuration from the parsed form values.
+func webhookFromForm(r *http.Request) (config.Webhook, error) {
+	cfg := config.Webhook{
+		URL:                r.FormValue("url"),
+		Tmpl:               r.FormValue("template"),
+		Condition:          strings.TrimSpace(r.FormValue("condition")),
+		FallbackBody:       r.FormValue("fallback_body"),
+		ContentType:        strings.TrimSpace(r.FormValue("content_type")),
+		IdempotencyHeader:  strings.TrimSpace(r.FormValue("idempotency_header")),
+		UserAgent:          strings.TrimSpace(r.FormValue("user_agent")),
+		RequireContentType: strings.TrimSpace(r.FormValue("require_content_type")),
+		Base64Body:         r.FormValue("base64_body") == "true",
+		RawRequest:         r.FormValue("raw_request") == "true",
+		CompressOutbound:   r.FormValue("compress_outbound") == "true",
+		JSONMode:           config.JSONMode(r.FormValue("json_mode")),
+		YAMLBody:           r.FormValue("yaml_body") == "true",
+		Multipart:          r.FormValue("multipart") == "true",
+		URLEncoded:         r.FormValue("urlencoded") == "true",
+		FailOnRemoteError:  r.FormValue("fail_on_remote_error") == "true",
+		DoublePass:         r.FormValue("double_pass") == "true",
+		SignatureProvider:  config.SignatureProvider(r.FormValue("signature_provider")),
+	}
+
+	if cfg.SignatureProvider != config.SignatureProviderNone {
+		cfg.Signature = &config.Signature{
+			Secret:    r.FormValue("signature_secret"),
+			Header:    r.FormValue("signature_header"),
+			Prefix:    r.FormValue("signature_prefix"),
+			Encoding:  r.FormValue("signature_encoding"),
+			Algorithm: r.FormValue("signature_algorithm"),
+		}
+	}
+
+	var err error
+	if rateLimit := r.FormValue("rate_limit"); rateLimit != "" {
+		if cfg.RateLimit, err = strconv.ParseFloat(rateLimit, 64); err != nil {
+			return config.Webhook{}, fmt.Errorf("invalid rate limit %q: %w", rateLimit, err)
+		}
+	}
+
+	if size := r.FormValue("max_body_bytes"); size != "" {
+		if cfg.MaxBodyBytes, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return config.Webhook{}, fmt.Errorf("invalid max body bytes %q: %w", size, err)
+		}
+	}
+
+	if timeout := r.FormValue("timeout"); timeout != "" {
+		if cfg.TimeoutSeconds, err = strconv.Atoi(timeout); err != nil {
+			return config.Webhook{}, fmt.Errorf("invalid timeout %q: %w", timeout, err)
+		}
+	}
+
+	if cfg.Partials, err = partialsFromForm(r); err != nil {
+		return config.Webhook{}, err
+	}
+
+	if cfg.Query, err = queryFromForm(r.FormValue("query")); err != nil {
+		return config.Webhook{}, err
+	}
+
+	if status := r.FormValue("force_status"); status != "" {
+		if cfg.ForceStatus, err = strconv.Atoi(status); err != nil {
+			return config.Webhook{}, fmt.Errorf("invalid forced status %q: %w", status, err)
+		}
+	}
+
+	if cfg.StatusMap, err = statusMapFromForm(r.FormValue("status_map")); err != nil {
+		return config.Webhook{}, err
+	}
+
+	cfg.AllowedMethods = splitList(strings.ToUpper(r.FormValue("allowed_methods")))
+	cfg.AllowedIPs = splitList(r.FormValue("allowed_ips"))
+	cfg.IncludeFields = splitList(r.FormValue("include_fields"))
+	cfg.ResponseHeaders = splitList(r.FormValue("response_headers"))
+	cfg.Delims = delimsFromForm(r)
+
+	if sch := strings.TrimSpace(r.FormValue("schema")); sch != "" {
+		buf := &bytes.Buffer{}
+		if err = json.Compact(buf, []byte(sch)); err != nil {
+			return config.Webhook{}, fmt.Errorf("invalid schema: %w", err)
+		}
+		cfg.Schema = buf.Bytes()
+	}
+
+	if consts := strings.TrimSpace(r.FormValue("constants")); consts != "" {
+		buf := &bytes.Buffer{}
+		if err = json.Compact(buf, []byte(consts)); err != nil {
+			return config.Webhook{}, fmt.Errorf("invalid constants: %w", err)
+		}
+		cfg.Constants = buf.Bytes()
+	}
+
+	if authType := config.AuthType(r.FormValue("auth_type")); authType != "" {
+		cfg.Auth = &config.Auth{
+			Type:  authType,
+			Token: r.FormValue("auth_token"),
+			User:  r.FormValue("auth_user"),
+			Pass:  r.FormValue("auth_pass"),
+		}
+	}
+
+	if secret := r.FormValue("signing_secret"); secret != "" {
+		cfg.OutboundSigning = &config.OutboundSigning{
+			Secret:    secret,
+			Header:    strings.TrimSpace(r.FormValue("signing_header")),
+			Algorithm: r.FormValue("signing_algorithm"),
+		}
+	}
+
+	return cfg, nil
+}
+
+// configureBody is the JSON body of the configure request. It is the
+// webhook configuration itself, with the template also accepted as
+// "template" and an optional sample payload for the curl command.
+type configureBody struct {
+	config.Webhook
+	Template string          `json:"template"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// webhookFromJSON builds the webhook configuration from the JSON body and
+// returns it along with the sample payload, if any.
+func webhookFromJSON(r *http.Request) (cfg config.Webhook, sample string, err error) {
+	var req configureBody
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&req); err != nil {
+		return config.Webhook{}, "", err
+	}
+	if req.Template != "" {
+		req.Tmpl = req.Template
+	}
+	return req.Webhook, string(req.Data), nil
+}
+
+// delimsFromForm returns the template delimiters from the form values,
+// the default delimiters are returned as empty to keep the token short.
+func delimsFromForm(r *http.Request) [2]string {
+	delims := [2]string{strings.TrimSpace(r.FormValue("delim_left")), strings.TrimSpace(r.FormValue("delim_right"))}
+	if delims == [2]string{"{{", "}}"} {
+		return [2]string{}
+	}
+	return delims
+}
+
+// queryFromForm parses the query parameters of the outbound URL, specified
+// one per line as name=template.
+func queryFromForm(s string) (map[string]string, error) {
+	var query map[string]string
+	for line := range strings.SplitSeq(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, tmpl, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid query parameter %q, expected name=template", line)
+		}
+		if query == nil {
+			query = map[string]string{}
+		}
+		query[strings.TrimSpace(name)] = strings.TrimSpace(tmpl)
+	}
+	return query, nil
+}
+
+// statusMapFromForm parses the remote response status replacements,
+// specified as comma or newline separated from->to pairs.
+func statusMapFromForm(s string) (map[int]int, error) {
+	var statuses map[int]int
+	for _, pair := range splitList(s) {
+		from, to, ok := strings.Cut(pair, "->")
+		fromCode, ferr := strconv.Atoi(strings.TrimSpace(from))
+		toCode, terr := strconv.Atoi(strings.TrimSpace(to))
+		if !ok || ferr != nil || terr != nil {
+			return nil, fmt.Errorf("invalid status mapping %q, expected from->to, e.g. 502->200", pair)
+		}
+		if statuses == nil {
+			statuses = map[int]int{}
+		}
+		statuses[fromCode] = toCode
+	}
+	return statuses, nil
+}
+
+// partialsFromForm returns the named partial templates from the form values,
+// passed as repeated partial_name and partial_body pairs.
+// Pairs with both name and body empty are skipped.
+func partialsFromForm(r *http.Request) (map[string]string, error) {
+	names, bodies := r.Form["partial_name"], r.Form["partial_body"]
+	if len(names) != len(bodies) {
+		return nil, fmt.Errorf("got %d partial names, but %d partial bodies", len(names), len(bodies))
+	}
+
+	var partials map[string]string
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" && strings.TrimSpace(bodies[i]) == "" {
+			continue
+		}
+		if partials == nil {
+			partials = map[string]string{}
+		}
+		partials[name] = bodies[i]
+	}
+	return partials, nil
+}
+
+// curlCommand returns a ready-to-paste curl command, which posts the sample
+// JSON body to the webhook URL.
+func curlCommand(webhookURL, body string) string {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
+	return "curl -X POST -H 'Content-Type: application/json' --data " + quote(body) + " " + quote(webhookURL)
+}
+
+// wantsJSON reports whether the web API request expects a JSON response
+// rather than an HTML fragment for HTMX.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || r.Header.Get("HX-Request") != "true"
+}
+
+// tokenFromInput accepts either a full webhook URL or just the bare token
+// and returns the token.
+func tokenFromInput(raw string) string {
+	token, _ := webhookFromInput(raw)
+	return token
+}
+
+// webhookFromInput accepts either a full webhook URL, possibly with the path
+// after the token, or just the bare token and returns the token and the
+// segments of the path after it.
+func webhookFromInput(raw string) (token string, path []string) {
+	if idx := strings.LastIndex(raw, "/wh/"); idx != -1 {
+		raw = raw[idx+len("/wh/"):]
+	}
+	token, rest, _ := strings.Cut(raw, "/")
+	return token, pathSegments(rest)
+}
+
+// splitList splits a comma or newline separated list, dropping empty items.
+func splitList(s string) []string {
+	var res []string
+	for item := range strings.FieldsFuncSeq(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// errCode is a stable, machine-readable code of the error response,
+// unlike the message, it doesn't change between versions.
+type errCode string
+
+// error codes of the error responses.
+const (
+	codeInvalidRequest       errCode = "invalid_request"
+	codeInvalidToken         errCode = "invalid_token"
+	codeInvalidConfig        errCode = "invalid_config"
+	codeInvalidJSON          errCode = "invalid_json"
+	codeInvalidPayload       errCode = "invalid_payload"
+	codeInvalidSignature     errCode = "invalid_signature"
+	codeSchemaMismatch       errCode = "schema_mismatch"
+	codeTemplateError        errCode = "template_error"
+	codeMethodNotAllowed     errCode = "method_not_allowed"
+	codeUnsupportedMediaType errCode = "unsupported_media_type"
+	codePayloadTooLarge      errCode = "payload_too_large"
+	codeRateLimited          errCode = "rate_limited"
+	codeOverloaded           errCode = "overloaded"
+	codeNotFound             errCode = "not_found"
+	codeUnauthorized         errCode = "unauthorized"
+	codeForbidden            errCode = "forbidden"
+	codeRemoteFailed         errCode = "remote_failed"
+	codeRemoteTimeout        errCode = "remote_timeout"
+	codeRemoteUnavailable    errCode = "remote_unavailable"
+	codeInternal             errCode = "internal_error"
+)
+
+// statusError is an error with the HTTP status code to respond with.
+type statusError struct {
+	status  int
+	code    errCode
+	err     error
+	details any // optional, structured details of the error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// withStatus formats the error and attaches the HTTP status code
+// and the error code to it.
+func withStatus(status int, code errCode, format string, args ...any) error {
+	return &statusError{status: status, code: code, err: fmt.Errorf(format, args...)}
+}
+
+// fail responds with the status code attached to the error,
+// or 500 Internal Server Error if there is none.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	serr, ok := errors.AsType[*statusError](err)
+	switch {
+	case ok && serr.details != nil:
+		s.errorWithDetails(w, r, serr.status, serr.code, serr.err, serr.details)
+		return
+	case ok:
+		s.error(w, r, serr.status, serr.code, "%v", serr.err)
+		return
+	}
+	s.error(w, r, http.StatusInternalServerError, codeInternal, "%v", err)
+}
+
+// errorWithDetails responds with the error and its structured details.
+func (s *Server) errorWithDetails(w http.ResponseWriter, r *http.Request, status int, code errCode, err error, details any) {
+	ctx := r.Context()
+
+	slog.WarnContext(ctx, "request failed",
+		slog.String("remote", r.RemoteAddr),
+		slog.Int("status", status), slog.String("code", string(code)), slogx.Error(err))
+
+	resp := struct {
+		Error   string  `json:"error"`
+		Code    errCode `json:"code"`
+		Details any     `json:"details"`
+	}{Error: err.Error(), Code: code, Details: details}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if werr := json.NewEncoder(w).Encode(resp); werr != nil {
+		slog.WarnContext(ctx, "failed to write error response", slogx.Error(werr))
+	}
+}
+
+// error responds with the formatted error message and the error code.
+func (s *Server) error(w http.ResponseWriter, r *http.Request, status int, code errCode, format string, args ...any) {
 	ctx := r.Context()
 	err := fmt.Errorf(format, args...)
 
 	slog.WarnContext(ctx, "request failed",
 		slog.String("remote", r.RemoteAddr),
-		slog.Int("status", status), slogx.Error(err))
+		slog.Int("status", status), slog.String("code", string(code)), slogx.Error(err))
 	w.WriteHeader(status)
-	if _, werr := fmt.Fprintf(w, `{"error": %q}`, err); werr != nil {
+	if _, werr := fmt.Fprintf(w, `{"error": %q, "code": %q}`, err, code); werr != nil {
 		slog.WarnContext(ctx, "failed to write error response", slogx.Error(werr))
 	}
 }
@@ -0,0 +1,21 @@
+package rest
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// overlayFS serves files from the upper file system, falling back
+// to the lower one for the files missing in the upper.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+// Open opens the named file from the upper file system, if it exists there.
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlayFS(t *testing.T) {
+	embedded, err := fs.Sub(webFS, "web")
+	require.NoError(t, err)
+
+	s := &Server{Version: "test"}
+	h := s.routes(overlayFS{
+		upper: fstest.MapFS{
+			"theme.css": {Data: []byte("header { background: url(logo.svg); }")},
+			"logo.svg":  {Data: []byte("<svg/>")},
+		},
+		lower: embedded,
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		return rec
+	}
+
+	t.Run("overridden file", func(t *testing.T) {
+		rec := get("/web/theme.css")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "header { background: url(logo.svg); }", rec.Body.String())
+	})
+
+	t.Run("added file", func(t *testing.T) {
+		rec := get("/web/logo.svg")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "<svg/>", rec.Body.String())
+	})
+
+	t.Run("embedded fallback", func(t *testing.T) {
+		rec := get("/web/")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `<link rel="stylesheet" href="theme.css">`)
+	})
+
+	t.Run("missing everywhere", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/web/missing.css").Code)
+	})
+}
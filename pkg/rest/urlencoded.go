@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// buildURLEncoded builds the application/x-www-form-urlencoded body from
+// the rendered JSON object of the form fields.
+func buildURLEncoded(spec []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(spec))
+	dec.UseNumber() // keep the numbers as rendered, e.g. large IDs
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("expected JSON object of fields: %w", err)
+	}
+	if fields == nil {
+		return nil, errors.New("expected JSON object of fields, got null")
+	}
+
+	values, err := formValues(fields)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
+
+// formValues converts the object into form values: scalars become single
+// values, arrays of scalars become repeated ones, null fields are omitted.
+// Nested objects have no standard form encoding, so they are rejected.
+func formValues(fields map[string]any) (url.Values, error) {
+	values := url.Values{}
+	for name, v := range fields {
+		switch v := v.(type) {
+		case nil:
+		case []any:
+			for _, item := range v {
+				s, err := formValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("field %q: %w", name, err)
+				}
+				values.Add(name, s)
+			}
+		default:
+			s, err := formValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", name, err)
+			}
+			values.Set(name, s)
+		}
+	}
+	return values, nil
+}
+
+// formValue returns the string value of the scalar form field.
+func formValue(v any) (string, error) {
+	switch v.(type) {
+	case map[string]any, []any:
+		return "", errors.New("nested objects and arrays can't be form-encoded")
+	case nil:
+		return "", nil
+	}
+	return str(v), nil
+}
@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildURLEncoded(t *testing.T) {
+	t.Run("fields", func(t *testing.T) {
+		body, err := buildURLEncoded([]byte(`{"text": "a&b c", "id": 12345678901234567890, "ok": true,
+			"tags": ["x", 1], "none": null, "empty": ""}`))
+		require.NoError(t, err)
+		assert.Equal(t, "empty=&id=12345678901234567890&ok=true&tags=x&tags=1&text=a%26b+c", string(body))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			spec    string
+			wantErr string
+		}{
+			{name: "not an object", spec: `["a"]`, wantErr: "expected JSON object of fields"},
+			{name: "null", spec: `null`, wantErr: "expected JSON object of fields, got null"},
+			{name: "nested object", spec: `{"user": {"id": 1}}`, wantErr: `field "user": nested objects and arrays can't be form-encoded`},
+			{name: "nested array", spec: `{"ids": [[1]]}`, wantErr: `field "ids": nested objects and arrays can't be form-encoded`},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := buildURLEncoded([]byte(tt.spec))
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
+}
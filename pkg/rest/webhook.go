@@ -0,0 +1,1108 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/Semior001/remapjson/pkg/schema"
+	"github.com/cappuccinotm/slogx"
+	"github.com/cappuccinotm/slogx/slogm"
+	"github.com/didip/tollbooth/v8"
+)
+
+// ANY /wh/<base64url-encoded-aes-gcm-sealed-config>[/<path>...]
+// sends a request to the remote server, remapping the incoming JSON to
+// the request, as specified by the sealed configuration token in the URL.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	cfg, err := s.unseal(r.PathValue("token"))
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidToken, "invalid token: %v", err)
+		return
+	}
+
+	if r.Method == http.MethodOptions && !slices.Contains(cfg.AllowedMethods, http.MethodOptions) {
+		// browsers preflight the cross-origin requests, the remote
+		// receives OPTIONS only when the webhook explicitly allows it
+		s.preflight(w, r, cfg)
+		return
+	}
+
+	if !cfg.AllowsMethod(r.Method) {
+		w.Header().Set("Allow", strings.Join(cfg.AllowedMethods, ", "))
+		s.error(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method %s is not allowed", r.Method)
+		return
+	}
+
+	if len(cfg.AllowedIPs) > 0 && !replayed(ctx) && !probed(ctx) {
+		// replays and test deliveries are sent by the operator
+		if ip, ierr := s.sourceIP(r); ierr != nil || !cfg.AllowsIP(ip) {
+			s.error(w, r, http.StatusForbidden, codeForbidden, "source IP %s is not allowed", ip)
+			return
+		}
+	}
+
+	if !cfg.AcceptsContentType(r.Header.Get("Content-Type")) {
+		s.error(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+			"content type %q is not accepted, expected %s", r.Header.Get("Content-Type"), cfg.RequireContentType)
+		return
+	}
+
+	if u, perr := url.Parse(cfg.URL); perr == nil {
+		setRemoteHost(w, u.Host)
+	}
+
+	if cfg.RateLimit > 0 {
+		herr, left := tollbooth.LimitByKeysAndReturn(s.tokenLimiter(cfg.RateLimit), []string{r.PathValue("token")})
+		if herr != nil {
+			w.Header().Set("Retry-After", retryAfter(cfg.RateLimit))
+			s.error(w, r, http.StatusTooManyRequests, codeRateLimited, "webhook rate limit exceeded")
+			return
+		}
+		// the global limit may be closer to exhaustion than the webhook one
+		if global, err := strconv.Atoi(w.Header().Get("X-RateLimit-Remaining")); err != nil || left < global {
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(left))
+		}
+	}
+
+	//nolint:gosec // cfg comes from operator-sealed token, log injection is accepted
+	slog.Info("handling request",
+		slog.String("remote_url", cfg.URL),
+		slog.String("template", cfg.Tmpl))
+
+	body, err := s.readBody(r, cfg)
+	if err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			s.error(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "%v", err)
+			return
+		}
+		s.error(w, r, http.StatusBadRequest, codeInvalidRequest, "failed to read request body: %v", err)
+		return
+	}
+
+	if err = cfg.VerifySignature(r.Header, body, time.Now()); err != nil && !replayed(ctx) && !probed(ctx) {
+		s.error(w, r, http.StatusUnauthorized, codeInvalidSignature, "signature verification failed: %v", err)
+		return
+	}
+
+	dryRun := s.dryRun(r)
+
+	replayKey := s.replayKey(r, cfg)
+	if dryRun {
+		replayKey = "" // dry runs are neither replayed, nor remembered
+	}
+	if rp, ok := s.replay(replayKey); ok {
+		rp.write(w, "X-Idempotent-Replay")
+		return
+	}
+
+	if key := s.coalesceKey(r, cfg, body); key != "" && !dryRun {
+		s.coalesce(w, r, key, func(w http.ResponseWriter) { s.deliver(w, r, cfg, body, false, replayKey) })
+		return
+	}
+	s.deliver(w, r, cfg, body, dryRun, replayKey)
+}
+
+// webhookMethods are listed in Allow of the webhooks that accept any method.
+var webhookMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+// preflight answers the OPTIONS request to the webhook with the allowed
+// methods, without forwarding it. Preflights from the origins allowed by
+// the CORS settings get the Access-Control-Allow-* headers as well.
+func (s *Server) preflight(w http.ResponseWriter, r *http.Request, cfg config.Webhook) {
+	methods := webhookMethods
+	if len(cfg.AllowedMethods) > 0 {
+		methods = append(slices.Clone(cfg.AllowedMethods), http.MethodOptions)
+	}
+	allow := strings.Join(methods, ", ")
+	w.Header().Set("Allow", allow)
+
+	origin := r.Header.Get("Origin")
+	if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" && s.CORS.allowOrigin(w, origin) {
+		w.Header().Set("Access-Control-Allow-Methods", allow)
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", "600")
+	}
+	if origin != "" {
+		w.Header().Add("Vary", "Origin")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deliver renders the outbound request from the incoming payload and sends
+// it to the remote, or responds with the rendered output, if the webhook is
+// local, or describes the request, if it is a dry run.
+func (s *Server) deliver(w http.ResponseWriter, r *http.Request, cfg config.Webhook, body []byte, dryRun bool, replayKey string) {
+	ctx := r.Context()
+
+	data, err := decodePayload(body, r.Header.Get("Content-Type"), s.LenientJSON, s.maxJSONDepth())
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidJSON, "%v", err)
+		return
+	}
+
+	in := inbound{raw: body, path: pathSegments(r.PathValue("rest")), header: r.Header, nonce: newNonce(),
+		disclosed: cfg.Local() || dryRun}
+	if in.consts, err = constants(cfg.Constants); err != nil {
+		s.error(w, r, http.StatusBadRequest, codeInvalidConfig, "invalid constants: %v", err)
+		return
+	}
+
+	forward, err := s.condition(cfg, data, in)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if !forward {
+		slog.InfoContext(ctx, "payload is skipped by condition")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rendered, err := s.render(cfg, data, in)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	s.logBodies(ctx, data, rendered)
+
+	if cfg.Local() {
+		s.respondLocally(w, r, cfg, rendered)
+		return
+	}
+
+	if timeout := s.timeout(cfg); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := s.outbound(ctx, cfg, r.Method, data, in, rendered)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if cfg.RawRequest {
+		setRemoteHost(w, req.URL.Host)
+	}
+
+	if dryRun {
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(describeRequest(req)); err != nil {
+			slog.WarnContext(ctx, "failed to write response", slogx.Error(err))
+		}
+		return
+	}
+
+	s.forward(w, r, req, cfg, replayKey)
+}
+
+// respondLocally writes the rendered output as the response to the caller,
+// with the configured content type and the status forced by the webhook
+// configuration, 200 by default.
+func (s *Server) respondLocally(w http.ResponseWriter, r *http.Request, cfg config.Webhook, body []byte) {
+	switch {
+	case cfg.ContentType != "":
+		w.Header().Set("Content-Type", cfg.ContentType)
+	case cfg.YAMLBody:
+		w.Header().Set("Content-Type", "application/yaml")
+	case json.Valid(body):
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(cfg.Status(http.StatusOK))
+	if _, err := w.Write(body); err != nil {
+		slog.WarnContext(r.Context(), "failed to write response", slogx.Error(err))
+	}
+}
+
+// timeout returns the timeout of the outbound request configured for the
+// webhook, or the client timeout by default, capped by the server maximum.
+// Returns 0 if the request is not limited at all.
+func (s *Server) timeout(cfg config.Webhook) time.Duration {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout == 0 && s.Client != nil {
+		timeout = s.Client.Timeout
+	}
+	if s.MaxTimeout > 0 && (timeout == 0 || timeout > s.MaxTimeout) {
+		timeout = s.MaxTimeout
+	}
+	return timeout
+}
+
+// dryRun reports whether the caller asked to render the outbound request
+// without sending it, and dry runs are allowed.
+func (s *Server) dryRun(r *http.Request) bool {
+	if !s.AllowDryRun {
+		return false
+	}
+	dryRun, err := strconv.ParseBool(r.Header.Get("X-RemapJSON-DryRun"))
+	return err == nil && dryRun
+}
+
+// decodeJSON decodes the incoming payload, an empty body is decoded as nil.
+func decodeJSON(body []byte) (any, error) {
+	var data any
+	if len(body) == 0 {
+		return data, nil
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// constants decodes the JSON object of the webhook constants,
+// nil if there are none.
+func constants(raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var consts map[string]any
+	if err := json.Unmarshal(raw, &consts); err != nil {
+		return nil, err
+	}
+	return consts, nil
+}
+
+// readBody reads the request body, decompressing it according to the
+// Content-Encoding header. The maximum body size, the webhook one if it's
+// lower than the server-wide limit, is applied to the decompressed body
+// to protect against decompression bombs.
+func (s *Server) readBody(r *http.Request, cfg config.Webhook) ([]byte, error) {
+	rd, err := decompress(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	size := s.maxBodySize()
+	if cfg.MaxBodyBytes > 0 {
+		size = min(size, cfg.MaxBodyBytes)
+	}
+	body, err := io.ReadAll(io.LimitReader(rd, size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > size {
+		return nil, fmt.Errorf("%w: body is larger than %d bytes", errBodyTooLarge, size)
+	}
+
+	return body, nil
+}
+
+// condition reports whether the payload should be forwarded, according to
+// the condition of the webhook configuration, if any.
+func (s *Server) condition(cfg config.Webhook, data any, in inbound) (bool, error) {
+	if cfg.Condition == "" {
+		return true, nil
+	}
+	out, err := s.renderString(cfg, cfg.Condition, withInbound(data, in), in)
+	if err != nil {
+		return false, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "failed to render condition: %w", err)
+	}
+	return truthy(out), nil
+}
+
+// truthy reports whether the rendered condition holds: empty output,
+// "false", "0", "no", "off" and "<no value>" of a missing field don't.
+func truthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "false", "0", "no", "off", "<no value>":
+		return false
+	}
+	return true
+}
+
+// render applies the webhook configuration to the decoded incoming payload
+// and returns the body of the outbound request. The inbound request details
+// are available to the template under the reserved keys of the payload object.
+func (s *Server) render(cfg config.Webhook, data any, in inbound) ([]byte, error) {
+	sch, err := s.schema(cfg)
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, codeInvalidConfig, "invalid schema: %w", err)
+	}
+	if sch != nil {
+		if err = sch.Validate(data); err != nil {
+			serr := &statusError{status: http.StatusUnprocessableEntity, code: codeSchemaMismatch, err: fmt.Errorf("payload does not match the schema: %w", err)}
+			if verr, ok := errors.AsType[*schema.ValidationError](err); ok {
+				serr.details = verr.Violations
+			}
+			return nil, serr
+		}
+	}
+
+	if len(cfg.IncludeFields) > 0 {
+		if data, err = project(data, cfg.IncludeFields); err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeInvalidPayload, "failed to project fields: %w", err)
+		}
+	}
+
+	if cfg.Tmpl == "" { // no template, forward the projected payload as is
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode projected payload: %w", err)
+		}
+		return reformat(cfg, b)
+	}
+
+	tmpl, err := s.template(cfg)
+	if err == nil {
+		tmpl, err = bindRequest(tmpl, cfg, in)
+	}
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, codeTemplateError, "invalid template: %w", err)
+	}
+
+	data = withInbound(data, in)
+
+	var limit int64 // the first pass output is a template, which is capped to not blow up in the second
+	if cfg.DoublePass {
+		limit = s.maxBodySize()
+	}
+	out, err := s.execute(tmpl, data, limit)
+	if err == nil && cfg.DoublePass {
+		out, err = s.executeRendered(cfg, out, data)
+	}
+	if err != nil && cfg.FallbackBody != "" {
+		slog.Warn("failed to execute template, sending fallback body", slogx.Error(err))
+		out, err = []byte(cfg.FallbackBody), nil
+	}
+	if err != nil {
+		if errors.Is(err, errTemplateTimeout) || errors.Is(err, errRenderedTemplate) || errors.Is(err, errOutputTooLarge) {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "%w", err)
+		}
+		execErr, ok := errors.AsType[template.ExecError](err)
+		if !ok { // not an error of the template itself
+			return nil, fmt.Errorf("failed to execute template: %w", err)
+		}
+
+		serr := &statusError{status: http.StatusUnprocessableEntity, code: codeTemplateError, details: templateErrorDetails(execErr)}
+		if kind := jsonKind(data); kind != "object" && kind != "null" && fieldAccessError(err) {
+			serr.err = fmt.Errorf("body shape mismatch: template expects an object, but got %s: %w", kind, err)
+			return nil, serr
+		}
+		serr.err = fmt.Errorf("failed to execute template: %w", err)
+		return nil, serr
+	}
+
+	if cfg.Base64Body {
+		if out, err = decodeBase64(string(out)); err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "rendered body is not valid base64: %w", err)
+		}
+	}
+
+	return reformat(cfg, out)
+}
+
+// errRenderedTemplate is returned when the output of the first pass of the
+// double pass rendering is not a valid template or is too large.
+var errRenderedTemplate = errors.New("invalid rendered template")
+
+// executeRendered parses the rendered output as a template and executes it
+// with the same data. Both the output and its rendering are capped by the
+// maximum body size, so that the passes can't grow the body without bound.
+// The rendered code may come from the payload, so it can't read the allowed
+// environment variables or the nonce, as local webhooks respond with it.
+// The parsed output is not cached, as it differs between payloads.
+func (s *Server) executeRendered(cfg config.Webhook, rendered []byte, data any) ([]byte, error) {
+	second := config.Webhook{Tmpl: string(rendered), Delims: cfg.Delims, Partials: cfg.Partials}
+	tmpl, err := s.parseTemplate(second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errRenderedTemplate, err)
+	}
+	tmpl.Funcs(secondPassFuncs)
+
+	out, err := s.execute(tmpl, data, s.maxBodySize())
+	if errors.Is(err, errOutputTooLarge) {
+		return nil, fmt.Errorf("%w: second pass: %w", errRenderedTemplate, err)
+	}
+	return out, err
+}
+
+// reformat applies the JSON mode to the rendered body and converts it
+// to YAML, if configured.
+func reformat(cfg config.Webhook, body []byte) ([]byte, error) {
+	body = formatJSON(body, cfg.JSONMode)
+	if !cfg.YAMLBody {
+		return body, nil
+	}
+	out, err := jsonToYAML(body)
+	if err != nil {
+		return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "rendered body can't be converted to YAML: %w", err)
+	}
+	return out, nil
+}
+
+// formatJSON reformats the rendered body according to the JSON mode,
+// bodies which are not valid JSON are returned as is.
+func formatJSON(body []byte, mode config.JSONMode) []byte {
+	buf := &bytes.Buffer{}
+	var err error
+	switch mode {
+	case config.JSONModeCompact:
+		err = json.Compact(buf, body)
+	case config.JSONModePretty:
+		err = json.Indent(buf, body, "", "  ")
+	default:
+		return body
+	}
+	if err != nil { // not JSON, e.g. XML or a raw request
+		return body
+	}
+	return buf.Bytes()
+}
+
+// Reserved keys of the payload object, which hold the inbound request details.
+const (
+	rawKey     = "_raw"     // raw incoming body
+	pathKey    = "_path"    // segments of the path after the token
+	headersKey = "_headers" // headers of the incoming request
+	constKey   = "_const"   // constants of the webhook configuration
+)
+
+// inbound holds the details of the incoming request, along with the
+// constants of the webhook, available to the template under the reserved
+// keys of the payload object, and the nonce returned by requestNonce.
+// If disclosed is set, the rendered output is shown to the caller, so the
+// templates can't read the environment variables.
+type inbound struct {
+	raw       []byte
+	path      []string
+	header    http.Header
+	consts    map[string]any
+	nonce     string
+	disclosed bool
+}
+
+// withInbound returns the copy of the payload object with the inbound
+// request details set under the reserved keys, payloads of other kinds
+// are returned as is.
+func withInbound(data any, in inbound) any {
+	m, ok := data.(map[string]any)
+	if !ok {
+		return data
+	}
+	path := in.path
+	if path == nil {
+		path = []string{} // keep it a list for range and len
+	}
+	header := in.header
+	if header == nil {
+		header = http.Header{} // keep .Get callable
+	}
+	consts := in.consts
+	if consts == nil {
+		consts = map[string]any{}
+	}
+	m = maps.Clone(m)
+	m[rawKey] = string(in.raw)
+	m[pathKey] = path
+	m[headersKey] = header
+	m[constKey] = consts
+	return m
+}
+
+// pathSegments splits the path after the token into segments,
+// dropping the empty ones.
+func pathSegments(rest string) []string {
+	var segments []string
+	for seg := range strings.SplitSeq(rest, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	return segments
+}
+
+// errTemplateTimeout is returned when the template execution takes longer
+// than the configured template timeout.
+var errTemplateTimeout = errors.New("template execution timed out")
+
+// errOutputTooLarge is returned when the template output exceeds the limit.
+var errOutputTooLarge = errors.New("template output is too large")
+
+// execute executes the template with the data, aborting with errTemplateTimeout
+// if it takes longer than the template timeout. Templates can't be interrupted,
+// so the aborted execution keeps running in the background until it completes.
+// The output is capped by the limit, if positive, the execution is aborted
+// with errOutputTooLarge once it's exceeded, so that it never piles up.
+func (s *Server) execute(tmpl *template.Template, data any, limit int64) ([]byte, error) {
+	if s.TemplateTimeout <= 0 {
+		buf := &cappedBuffer{limit: limit}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1) // buffered, so that the abandoned execution doesn't block forever
+
+	go func() {
+		buf := &cappedBuffer{limit: limit}
+		err := tmpl.Execute(buf, data)
+		done <- result{out: buf.Bytes(), err: err}
+	}()
+
+	timer := time.NewTimer(s.TemplateTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.out, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w after %s", errTemplateTimeout, s.TemplateTimeout)
+	}
+}
+
+// cappedBuffer is a buffer failing the writes beyond the limit,
+// 0 means no limit.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+// Write appends the data to the buffer, unless it exceeds the limit.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && int64(b.Len()+len(p)) > b.limit {
+		return 0, fmt.Errorf("%w, over %d bytes", errOutputTooLarge, b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// outbound builds the request to the remote with the rendered body,
+// data is the decoded incoming payload, with the inbound request details,
+// to render the credentials with.
+func (s *Server) outbound(ctx context.Context, cfg config.Webhook, method string, data any, in inbound, body []byte) (*http.Request, error) {
+	data = withInbound(data, in)
+	target := cfg.URL
+	var header http.Header
+	if cfg.RawRequest {
+		raw, err := parseRawRequest(body)
+		if err == nil {
+			err = s.checkURL(raw.url)
+		}
+		if err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "invalid raw request: %w", err)
+		}
+		method, target, header, body = raw.method, raw.url, raw.header, raw.body
+	}
+	if cfg.Multipart {
+		form, contentType, err := buildMultipart(body)
+		if err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "invalid multipart form: %w", err)
+		}
+		header, body = http.Header{"Content-Type": {contentType}}, form
+	}
+	if cfg.URLEncoded {
+		form, err := buildURLEncoded(body)
+		if err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "invalid url-encoded form: %w", err)
+		}
+		header, body = http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, form
+	}
+
+	payload := body // as sent, the body may be compressed
+	compressed := cfg.CompressOutbound && len(body) >= minCompressSize
+	if compressed {
+		var err error
+		if payload, err = compress(body); err != nil {
+			return nil, fmt.Errorf("failed to compress body: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if !supportedScheme(req.URL.Scheme) {
+		// e.g. ws:// allowed by the operator, or sealed offline
+		return nil, withStatus(http.StatusBadRequest, codeInvalidConfig,
+			"unsupported scheme %q of the remote URL, only http and https are supported", req.URL.Scheme)
+	}
+
+	if len(cfg.Query) > 0 {
+		q := req.URL.Query()
+		for _, name := range slices.Sorted(maps.Keys(cfg.Query)) {
+			val, rerr := s.renderString(cfg, cfg.Query[name], data, in)
+			if rerr != nil {
+				return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "failed to render query parameter %q: %w", name, rerr)
+			}
+			q.Set(name, val)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+
+	switch {
+	case cfg.ContentType != "":
+		req.Header.Set("Content-Type", cfg.ContentType)
+	case cfg.YAMLBody:
+		req.Header.Set("Content-Type", "application/yaml")
+	case json.Valid(body):
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("User-Agent", s.userAgent(cfg))
+
+	if name := s.requestIDHeader(); name != "" {
+		if reqID, ok := slogm.RequestIDFromContext(ctx); ok {
+			req.Header.Set(name, reqID) // to correlate the traces across the hops
+		}
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Headers)) {
+		val, rerr := s.renderString(cfg, cfg.Headers[name], data, in)
+		if rerr != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "failed to render header %q: %w", name, rerr)
+		}
+		req.Header.Set(name, val)
+	}
+
+	if cfg.Auth != nil {
+		if err = s.authorize(req, cfg, data, in); err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "failed to render credentials: %w", err)
+		}
+	}
+
+	if cfg.OutboundSigning != nil {
+		signing := *cfg.OutboundSigning
+		if signing.Secret, err = s.renderString(cfg, signing.Secret, data, in); err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, codeTemplateError, "failed to render signing secret: %w", err)
+		}
+		if err = signing.Sign(req.Header, payload); err != nil {
+			return nil, fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
+
+	return req, nil
+}
+
+// supportedScheme reports whether the outbound requests can be sent
+// to the URLs of the scheme.
+func supportedScheme(scheme string) bool {
+	return strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https")
+}
+
+// rawRequest is the outbound request described by the rendered template.
+type rawRequest struct {
+	method string
+	url    string
+	header http.Header
+	body   []byte
+}
+
+var methodRe = regexp.MustCompile(`^[A-Z]+$`)
+
+// parseRawRequest parses the rendered raw request: the request line
+// "METHOD URL [HTTP/x.y]", the headers, a blank line and the body.
+// Leading blank lines are ignored, the body is taken as is.
+func parseRawRequest(b []byte) (rawRequest, error) {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(bytes.TrimLeft(b, " \t\r\n"))))
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return rawRequest{}, errors.New("missing request line")
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/") {
+		fields = fields[:2] // the protocol version is chosen by the client
+	}
+	if len(fields) != 2 {
+		return rawRequest{}, fmt.Errorf("malformed request line %q, expected \"METHOD URL\"", line)
+	}
+	if !methodRe.MatchString(fields[0]) {
+		return rawRequest{}, fmt.Errorf("invalid method %q", fields[0])
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return rawRequest{}, fmt.Errorf("malformed headers: %w", err)
+	}
+
+	body, err := io.ReadAll(tp.R)
+	if err != nil {
+		return rawRequest{}, fmt.Errorf("read body: %w", err)
+	}
+
+	return rawRequest{method: fields[0], url: fields[1], header: http.Header(header), body: body}, nil
+}
+
+// authorize sets the credentials of the outbound request, rendering them
+// with the incoming payload.
+func (s *Server) authorize(req *http.Request, cfg config.Webhook, data any, in inbound) error {
+	switch cfg.Auth.Type {
+	case config.AuthTypeBearer:
+		token, err := s.renderString(cfg, cfg.Auth.Token, data, in)
+		if err != nil {
+			return fmt.Errorf("token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case config.AuthTypeBasic:
+		user, err := s.renderString(cfg, cfg.Auth.User, data, in)
+		if err != nil {
+			return fmt.Errorf("user: %w", err)
+		}
+		pass, err := s.renderString(cfg, cfg.Auth.Pass, data, in)
+		if err != nil {
+			return fmt.Errorf("pass: %w", err)
+		}
+		req.SetBasicAuth(user, pass)
+	default:
+		return fmt.Errorf("unknown auth type %q", cfg.Auth.Type)
+	}
+	return nil
+}
+
+// renderString executes the small template, such as a header value,
+// with the delimiters of the webhook configuration.
+func (s *Server) renderString(cfg config.Webhook, tstr string, data any, in inbound) (string, error) {
+	if !strings.Contains(tstr, cmp.Or(cfg.Delims[0], "{{")) {
+		return tstr, nil // nothing to execute, e.g. a static header
+	}
+	small := config.Webhook{URL: cfg.URL, Tmpl: tstr, Delims: cfg.Delims}
+	tmpl, err := s.template(small)
+	if err == nil {
+		tmpl, err = bindRequest(tmpl, small, in)
+	}
+	if err != nil {
+		return "", err
+	}
+	out, err := s.execute(tmpl, data, 0)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// forward sends the outbound request to the remote and proxies its response
+// back to the caller, passing through the response headers allowed by the
+// webhook configuration. If replayKey is set, the response is remembered
+// to be replayed on retries.
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, req *http.Request, cfg config.Webhook, replayKey string) {
+	client := s.Client
+	timeout := s.timeout(cfg)
+	if timeout > 0 {
+		// the deadline is set on the request context, which may exceed the client timeout
+		c := *s.Client
+		c.Timeout = 0
+		client = &c
+	}
+
+	host := req.URL.Host
+	brk := s.breaker(host)
+	if brk != nil {
+		if ok, retryIn := brk.allow(time.Now(), s.BreakerCooldown); !ok {
+			w.Header().Set("Retry-After", retryAfterDuration(retryIn))
+			s.error(w, r, http.StatusServiceUnavailable, codeRemoteUnavailable, "remote %s is unavailable, circuit breaker is open", host)
+			return
+		}
+	}
+
+	start := time.Now()
+	//nolint:gosec // request URL comes from operator-sealed token, SSRF is accepted by design
+	resp, err := client.Do(req)
+	if err == nil && !s.NoRemoteLog {
+		// neither the body nor the configuration, the token is fingerprinted
+		slog.InfoContext(r.Context(), "remote responded",
+			slog.String("token", config.Fingerprint(r.PathValue("token"))),
+			slog.String("host", host),
+			slog.Int("status", resp.StatusCode),
+			slog.Duration("latency", time.Since(start)))
+	}
+	if r.Context().Err() == nil { // failures caused by the caller don't tell about the remote
+		s.recordRemote(r.Context(), brk, host, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil {
+			s.error(w, r, http.StatusGatewayTimeout, codeRemoteTimeout, "remote did not respond within %s: %v", timeout, err)
+			return
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			s.error(w, r, http.StatusBadGateway, codeRemoteFailed, "remote refused the connection: %v", err)
+			return
+		}
+		if errors.Is(err, ErrRedirectHost) {
+			s.error(w, r, http.StatusBadGateway, codeRemoteFailed, "%v", err)
+			return
+		}
+		s.error(w, r, http.StatusInternalServerError, codeRemoteFailed, "failed to send request: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if limit := s.MaxResponseSize; limit > 0 {
+		if resp.ContentLength > limit {
+			s.error(w, r, http.StatusBadGateway, codeRemoteFailed, "remote response is larger than %d bytes", limit)
+			return
+		}
+		body = &cappedReader{r: resp.Body, left: limit}
+	}
+
+	if cfg.FailOnRemoteError && (resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices) {
+		s.remoteError(w, r, resp.StatusCode, body)
+		return
+	}
+
+	status := cfg.Status(resp.StatusCode)
+	if status != resp.StatusCode {
+		slog.InfoContext(r.Context(), "remote response status is overridden",
+			slog.Int("remote_status", resp.StatusCode), slog.Int("status", status))
+	}
+
+	header := responseHeaders(resp.Header, cfg.ResponseHeaders)
+	copyHeaders(w.Header(), header)
+	w.WriteHeader(status)
+
+	if replayKey == "" || resp.StatusCode >= http.StatusInternalServerError {
+		err = s.copyResponse(r.Context(), w, body)
+	} else {
+		rec := &limitedBuffer{limit: s.maxBodySize()}
+		if err = s.copyResponse(r.Context(), w, io.TeeReader(body, rec)); err == nil && !rec.overflow {
+			s.remember(replayKey, replay{status: status, header: header, body: rec.Bytes()})
+		}
+	}
+
+	if errors.Is(err, errResponseTooLarge) {
+		// the status is already sent, close the connection without finishing
+		// the body, so that the caller doesn't take the truncated body as a
+		// complete one, HTTP/2 connections can't be hijacked and end as is
+		if conn, _, herr := http.NewResponseController(w).Hijack(); herr == nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+// remoteError responds with 502 Bad Gateway to the remote error response,
+// with the remote status in the details. The remote body is logged along,
+// if the bodies are logged.
+func (s *Server) remoteError(w http.ResponseWriter, r *http.Request, status int, body io.Reader) {
+	if s.Debug && s.LogBodies {
+		b, _ := io.ReadAll(io.LimitReader(body, maxLoggedBody+1))
+		slog.DebugContext(r.Context(), "remote error response",
+			slog.Int("remote_status", status), slog.String("body", truncate(string(b), maxLoggedBody)))
+	}
+
+	details := struct {
+		RemoteStatus int `json:"remote_status"`
+	}{RemoteStatus: status}
+	s.errorWithDetails(w, r, http.StatusBadGateway, codeRemoteFailed,
+		fmt.Errorf("remote responded with %d %s", status, http.StatusText(status)), details)
+}
+
+// errResponseTooLarge is returned by cappedReader when the remote response
+// exceeds the maximum response size.
+var errResponseTooLarge = errors.New("remote response is too large")
+
+// ErrRedirectHost is returned by SameHostRedirects for redirects
+// to another host, the webhook responds with 502 Bad Gateway then.
+var ErrRedirectHost = errors.New("redirect to another host is not allowed")
+
+// SameHostRedirects is the http.Client CheckRedirect policy, which follows
+// up to 10 redirects within the host of the original request only.
+func SameHostRedirects(req *http.Request, via []*http.Request) error {
+	if req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("%w: %s redirected to %s", ErrRedirectHost, via[0].URL.Host, req.URL.Host)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// cappedReader reads up to left bytes, failing with errResponseTooLarge
+// if the underlying reader has more.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > c.left+1 {
+		p = p[:c.left+1] // read one byte more to detect the overflow
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left < 0 {
+		return n + int(c.left), errResponseTooLarge
+	}
+	return n, err
+}
+
+// hopByHopHeaders are meaningful only for a single connection
+// and must not be passed through, as per RFC 9110, section 7.6.1.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// responseHeaders returns the remote response headers to pass through to
+// the caller: hop-by-hop headers and Content-Length are always dropped,
+// if allow is not empty, only the listed headers are kept.
+func responseHeaders(src http.Header, allow []string) http.Header {
+	dst := src.Clone()
+	for _, f := range src.Values("Connection") {
+		for name := range strings.FieldsFuncSeq(f, func(r rune) bool { return r == ',' || r == ' ' }) {
+			dst.Del(name)
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		dst.Del(name)
+	}
+	dst.Del("Content-Length") // body is proxied as is, let the server compute it
+
+	if len(allow) == 0 {
+		return dst
+	}
+
+	allowed := http.Header{}
+	for _, name := range allow {
+		if vals := dst.Values(name); len(vals) > 0 {
+			allowed[http.CanonicalHeaderKey(name)] = vals
+		}
+	}
+	return allowed
+}
+
+// copyHeaders adds the headers to dst, skipping the ones already set
+// by the middlewares, such as the app info or rate limit headers.
+func copyHeaders(dst, src http.Header) {
+	for name, vals := range src {
+		if _, exists := dst[name]; exists {
+			continue
+		}
+		dst[name] = slices.Clone(vals)
+	}
+}
+
+// copyResponse copies the remote response body to the caller, distinguishing
+// failures to write to the caller (e.g. the client disconnected) from
+// failures to read the remote body. On caller failures the remote body is
+// drained so that the connection can be reused.
+func (s *Server) copyResponse(ctx context.Context, w io.Writer, body io.Reader) error {
+	cw := &errWriter{w: w}
+	_, err := io.Copy(cw, body)
+	switch {
+	case err == nil:
+		return nil
+	case cw.err != nil:
+		n := s.copyFailures.client.Add(1)
+		drained, derr := io.Copy(io.Discard, io.LimitReader(body, maxDrainSize))
+		slog.WarnContext(ctx, "failed to write response body to client",
+			slog.Int64("drained_bytes", drained),
+			slog.Int64("client_failures_total", n),
+			slogx.Error(errors.Join(cw.err, derr)))
+	default:
+		n := s.copyFailures.remote.Add(1)
+		slog.WarnContext(ctx, "failed to read response body from remote",
+			slog.Int64("remote_failures_total", n),
+			slogx.Error(err))
+	}
+	return err
+}
+
+// errWriter remembers the last error returned by the underlying writer.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// templateError describes where and why the template execution failed.
+type templateError struct {
+	Template string `json:"template,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Message  string `json:"message"`
+}
+
+// execErrorRe matches the text/template execution error message, e.g.
+// template: name:1:2: executing "name" at <.field>: can't evaluate field
+var execErrorRe = regexp.MustCompile(`^template: (.*?):(\d+):(\d+): executing ".*?" at <(.*?)>: (.*)$`)
+
+// templateErrorDetails extracts the location and the cause of the failure
+// from the template execution error.
+func templateErrorDetails(err template.ExecError) templateError {
+	m := execErrorRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return templateError{Template: err.Name, Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[2]) // matched as digits
+	col, _ := strconv.Atoi(m[3])
+	return templateError{Template: m[1], Line: line, Column: col, Action: m[4], Message: m[5]}
+}
+
+// fieldAccessError reports whether the template failed to access a field
+// of the value, e.g. of an array instead of an object, unlike the failures
+// of function calls or indexing.
+func fieldAccessError(err error) bool {
+	return strings.Contains(err.Error(), "can't evaluate field")
+}
+
+// jsonKind returns the JSON type name of the value decoded by encoding/json.
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
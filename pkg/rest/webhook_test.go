@@ -0,0 +1,2164 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/Semior001/remapjson/pkg/config"
+	"github.com/Semior001/remapjson/pkg/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookRequest(method, token, body string) *http.Request {
+	var bodyReader *strings.Reader
+	if body != "" {
+		bodyReader = strings.NewReader(body)
+	} else {
+		bodyReader = strings.NewReader("")
+	}
+	req := httptest.NewRequest(method, "/wh/"+token, bodyReader)
+	req.SetPathValue("token", token)
+	return req
+}
+
+func TestHandle(t *testing.T) {
+	t.Run("invalid token returns 400", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		req := httptest.NewRequest(http.MethodGet, "/wh/!!!notbase64!!!", http.NoBody)
+		req.SetPathValue("token", "!!!notbase64!!!")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid token")
+		assert.Contains(t, rec.Body.String(), `"code": "invalid_token"`)
+	})
+
+	t.Run("token from wrong secret returns 400", func(t *testing.T) {
+		s1 := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "secret-a"}, Client: &http.Client{}}
+		s2 := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "secret-b"}, Client: &http.Client{}}
+
+		token, err := s1.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodGet, token, `{"value":"hello"}`)
+		rec := httptest.NewRecorder()
+		s2.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid token")
+	})
+
+	t.Run("token from retired secret is accepted", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer remote.Close()
+
+		old := config.Sealer{Secret: "secret-a"}
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test",
+			Sealer: config.Sealer{Secret: "secret-b", Retired: []string{"secret-a"}}, Client: remote.Client()}
+
+		token, err := old.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("disallowed method returns 405 with Allow", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: "{{.v}}", AllowedMethods: []string{"POST", "PUT"}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodGet, token, ""))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, "POST, PUT", rec.Header().Get("Allow"))
+		assert.JSONEq(t, `{"error":"method GET is not allowed","code":"method_not_allowed"}`, rec.Body.String())
+	})
+
+	t.Run("OPTIONS is answered without forwarding", func(t *testing.T) {
+		var calls atomic.Int32
+		var method string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			method = r.Method
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), CORS: CORS{Origins: []string{"https://app.example.com"}}}
+
+		t.Run("any method", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.v}}"})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodOptions, token, ""))
+
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Equal(t, "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS", rec.Header().Get("Allow"))
+			assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Zero(t, calls.Load())
+		})
+
+		t.Run("preflight from allowed origin", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.v}}", AllowedMethods: []string{"POST"}})
+			require.NoError(t, err)
+
+			req := webhookRequest(http.MethodOptions, token, "")
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "content-type")
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, req)
+
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Equal(t, "POST, OPTIONS", rec.Header().Get("Allow"))
+			assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "POST, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, "content-type", rec.Header().Get("Access-Control-Allow-Headers"))
+			assert.Zero(t, calls.Load())
+		})
+
+		t.Run("preflight from other origin", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.v}}"})
+			require.NoError(t, err)
+
+			req := webhookRequest(http.MethodOptions, token, "")
+			req.Header.Set("Origin", "https://evil.example.com")
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, req)
+
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Zero(t, calls.Load())
+		})
+
+		t.Run("explicitly allowed", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v":1}`,
+				AllowedMethods: []string{"POST", "OPTIONS"}})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodOptions, token, ""))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, int32(1), calls.Load())
+			assert.Equal(t, http.MethodOptions, method)
+		})
+	})
+
+	t.Run("source IP outside the allowlist returns 403", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer remote.Close()
+
+		tests := []struct {
+			name       string
+			trusted    []netip.Prefix
+			remoteAddr string
+			xff        string
+			want       int
+		}{
+			{name: "allowed peer", remoteAddr: "192.30.252.10:1234", want: http.StatusOK},
+			{name: "other peer", remoteAddr: "198.51.100.1:1234", want: http.StatusForbidden},
+			{name: "spoofed header without trusted proxies", remoteAddr: "198.51.100.1:1234", xff: "192.30.252.10",
+				want: http.StatusForbidden},
+			{name: "forwarded to Unix socket", remoteAddr: "@", xff: "192.30.252.10", want: http.StatusOK},
+			{name: "Unix socket without forwarded IP", remoteAddr: "@", want: http.StatusForbidden},
+			{name: "forwarded by trusted proxy", trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				remoteAddr: "10.0.0.1:1234", xff: "192.30.252.10", want: http.StatusOK},
+			{name: "spoofed header behind trusted proxy", trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				remoteAddr: "10.0.0.1:1234", xff: "192.30.252.10, 198.51.100.1", want: http.StatusForbidden},
+			{name: "spoofed header from untrusted peer", trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				remoteAddr: "198.51.100.1:1234", xff: "192.30.252.10", want: http.StatusForbidden},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+					Client: remote.Client(), TrustedProxies: tt.trusted, NoWebUI: true}
+
+				token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.v}}", AllowedIPs: []string{"192.30.252.0/22"}})
+				require.NoError(t, err)
+
+				req := httptest.NewRequest(http.MethodPost, "/wh/"+token, strings.NewReader(`{"v":1}`))
+				req.RemoteAddr = tt.remoteAddr
+				if tt.xff != "" {
+					req.Header.Set("X-Forwarded-For", tt.xff)
+				}
+				rec := httptest.NewRecorder()
+				s.routes(webFS).ServeHTTP(rec, req)
+				assert.Equal(t, tt.want, rec.Code)
+				if tt.want == http.StatusForbidden {
+					assert.Contains(t, rec.Body.String(), `"code": "forbidden"`)
+				}
+			})
+		}
+	})
+
+	t.Run("unexpected content type returns 415", func(t *testing.T) {
+		var called bool
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.v}}", RequireContentType: "application/json"})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `v=1`)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+		assert.JSONEq(t, `{"error":"content type \"application/x-www-form-urlencoded\" is not accepted, expected application/json",`+
+			`"code":"unsupported_media_type"}`, rec.Body.String())
+		assert.False(t, called)
+
+		req = webhookRequest(http.MethodPost, token, `{"v":1}`)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, called)
+	})
+
+	t.Run("invalid JSON body returns 400", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodGet, token, "not-json")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid JSON")
+	})
+
+	t.Run("too deeply nested JSON body returns 400", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: &http.Client{}, MaxJSONDepth: 3}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodGet, token, `{"value": [[[1]]]}`)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"error": "invalid JSON: nesting depth exceeds 3", "code": "invalid_json"}`, rec.Body.String())
+	})
+
+	t.Run("forwards transformed body and proxies remote response", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"mapped":"{{.value}}"}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `{"value":"hello"}`)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.JSONEq(t, `{"ok":true}`, rec.Body.String())
+		assert.JSONEq(t, `{"mapped":"hello"}`, capturedBody)
+	})
+
+	t.Run("empty body is forwarded with nil data", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `static-payload`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, "")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "static-payload", capturedBody)
+	})
+
+	t.Run("non-object body with field access returns 422", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+
+		for _, body := range []string{`[1,2,3]`, `"text"`, `42`} {
+			req := webhookRequest(http.MethodPost, token, body)
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+			assert.Contains(t, rec.Body.String(), "body shape mismatch", body)
+		}
+	})
+
+	t.Run("non-object body with other execution error is not a shape mismatch", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		for _, tmpl := range []string{`{{index . 5}}`, `{{b64dec "%%%"}}`} {
+			token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: tmpl})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `[1,2,3]`))
+
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, tmpl)
+			assert.Contains(t, rec.Body.String(), "failed to execute template", tmpl)
+			assert.NotContains(t, rec.Body.String(), "body shape mismatch", tmpl)
+		}
+	})
+
+	t.Run("template execution error returns 422 with details", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: `{"v": {{index .items 5}}}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `{"items":[1,2]}`)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		var resp struct {
+			Error   string        `json:"error"`
+			Details templateError `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Contains(t, resp.Error, "failed to execute template")
+		assert.Equal(t, 1, resp.Details.Line)
+		assert.Equal(t, 8, resp.Details.Column)
+		assert.Equal(t, "index .items 5", resp.Details.Action)
+		assert.Contains(t, resp.Details.Message, "index out of range")
+	})
+
+	t.Run("payload not matching the schema returns 422 with violations", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{
+			URL:    "http://remote.example.com",
+			Tmpl:   `{"v": {{.id}}}`,
+			Schema: []byte(`{"type":"object","required":["id"],"properties":{"id":{"type":"integer"},"tags":{"items":{"type":"string"}}}}`),
+		})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `{"tags":["a",1]}`)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		var resp struct {
+			Error   string             `json:"error"`
+			Code    string             `json:"code"`
+			Details []schema.Violation `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Contains(t, resp.Error, "payload does not match the schema")
+		assert.Equal(t, "schema_mismatch", resp.Code)
+		assert.Equal(t, []schema.Violation{
+			{Path: "", Message: "missing property 'id'"},
+			{Path: "/tags/1", Message: "got number, want string"},
+		}, resp.Details)
+	})
+
+	t.Run("non-object body is accepted by template not accessing fields", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{range .}}{{.}};{{end}}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `["a","b"]`)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "a;b;", capturedBody)
+	})
+
+	t.Run("signature is verified with provider preset", func(t *testing.T) {
+		var called bool
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{
+			URL: remote.URL, Tmpl: `{{.value}}`,
+			SignatureProvider: config.SignatureProviderGitHub,
+			Signature:         &config.Signature{Secret: "gh-secret"},
+		})
+		require.NoError(t, err)
+
+		const body = `{"value":"hello"}`
+		mac := hmac.New(sha256.New, []byte("gh-secret"))
+		_, _ = mac.Write([]byte(body))
+
+		req := webhookRequest(http.MethodPost, token, body)
+		req.Header.Set("X-Hub-Signature-256", "sha256=deadbeef")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.False(t, called)
+
+		req = webhookRequest(http.MethodPost, token, body)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, called)
+	})
+
+	t.Run("per-token rate limit returns 429 with Retry-After", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		limited, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, RateLimit: 0.5})
+		require.NoError(t, err)
+		other, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, RateLimit: 0.5})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("X-RateLimit-Remaining", "5") // set by the global limiter
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, limited, `{}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"), "the lower of the limits is reported")
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, limited, `{}`))
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+		// other tokens are limited independently
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, other, `{}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("include fields are projected before templating", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		const body = `{"user":{"id":1,"password":"secret"},"event":"signup"}`
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, IncludeFields: []string{"user.id", "event"}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, body))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"user":{"id":1},"event":"signup"}`, capturedBody)
+
+		token, err = s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{len .user}}`, IncludeFields: []string{"user.id"}})
+		require.NoError(t, err)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, body))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "1", capturedBody)
+	})
+
+	t.Run("compressed bodies are decompressed", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"mapped":"{{.value}}"}`})
+		require.NoError(t, err)
+
+		gzBuf := &bytes.Buffer{}
+		gz := gzip.NewWriter(gzBuf)
+		_, err = gz.Write([]byte(`{"value":"gzipped"}`))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		req := webhookRequest(http.MethodPost, token, gzBuf.String())
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"mapped":"gzipped"}`, capturedBody)
+
+		zBuf := &bytes.Buffer{}
+		zw := zlib.NewWriter(zBuf)
+		_, err = zw.Write([]byte(`{"value":"deflated"}`))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		req = webhookRequest(http.MethodPost, token, zBuf.String())
+		req.Header.Set("Content-Encoding", "deflate")
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"mapped":"deflated"}`, capturedBody)
+	})
+
+	t.Run("decompressed body over the limit returns 413", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: &http.Client{}, MaxBodySize: 1024}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: `{{.value}}`})
+		require.NoError(t, err)
+
+		gzBuf := &bytes.Buffer{}
+		gz := gzip.NewWriter(gzBuf)
+		_, err = gz.Write([]byte(`{"value":"` + strings.Repeat("a", 4096) + `"}`))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		require.Less(t, gzBuf.Len(), 1024)
+
+		req := webhookRequest(http.MethodPost, token, gzBuf.String())
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("body over the webhook limit returns 413", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), MaxBodySize: 1024}
+
+		for _, tt := range []struct {
+			name  string
+			limit int64
+			body  string
+			want  int
+		}{
+			{name: "under the limit", limit: 32, body: `{"value":"small"}`, want: http.StatusOK},
+			{name: "over the limit", limit: 32, body: `{"value":"` + strings.Repeat("a", 64) + `"}`, want: http.StatusRequestEntityTooLarge},
+			{name: "server-wide limit caps the webhook one", limit: 4096, body: `{"value":"` + strings.Repeat("a", 2048) + `"}`,
+				want: http.StatusRequestEntityTooLarge},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, MaxBodyBytes: tt.limit})
+				require.NoError(t, err)
+
+				rec := httptest.NewRecorder()
+				s.handleWebhook(rec, webhookRequest(http.MethodPost, token, tt.body))
+				assert.Equal(t, tt.want, rec.Code)
+				if tt.want == http.StatusRequestEntityTooLarge {
+					assert.Contains(t, rec.Body.String(), `"code": "payload_too_large"`)
+				}
+			})
+		}
+	})
+
+	t.Run("unsupported content encoding returns 400", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: `{{.value}}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `{"value":"x"}`)
+		req.Header.Set("Content-Encoding", "br")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "unsupported content encoding")
+	})
+
+	t.Run("content type is set on the outbound request", func(t *testing.T) {
+		var capturedBody, capturedContentType string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody, capturedContentType = string(b), r.Header.Get("Content-Type")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `<event><value>{{.value}}</value></event>`,
+			ContentType: "application/xml"})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "<event><value>hello</value></event>", capturedBody)
+		assert.Equal(t, "application/xml", capturedContentType)
+
+		token, err = s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"value":"{{.value}}"}`})
+		require.NoError(t, err)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", capturedContentType, "JSON output defaults to application/json")
+
+		token, err = s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `plain {{.value}}`})
+		require.NoError(t, err)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, capturedContentType, "non-JSON output without configured content type")
+	})
+
+	t.Run("form-encoded payload is remapped to JSON", func(t *testing.T) {
+		var capturedBody, capturedContentType string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody, capturedContentType = string(b), r.Header.Get("Content-Type")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"user": "{{.user_name}}", "text": "{{.text}}"}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, "user_name=alice&text=%2Fdeploy+prod")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"user": "alice", "text": "/deploy prod"}`, capturedBody)
+		assert.Equal(t, "application/json", capturedContentType)
+	})
+
+	t.Run("outbound request is authenticated", func(t *testing.T) {
+		var capturedAuth string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			capturedAuth = r.Header.Get("Authorization")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		tests := []struct {
+			name   string
+			auth   config.Auth
+			header http.Header
+			want   string
+		}{
+			{name: "static bearer", auth: config.Auth{Type: config.AuthTypeBearer, Token: "static"}, want: "Bearer static"},
+			{name: "templated bearer", auth: config.Auth{Type: config.AuthTypeBearer, Token: "{{.key}}"}, want: "Bearer from-payload"},
+			{
+				name:   "bearer from incoming header",
+				auth:   config.Auth{Type: config.AuthTypeBearer, Token: `{{._headers.Get "X-Tenant-Token"}}`},
+				header: http.Header{"X-Tenant-Token": {"tenant-42"}},
+				want:   "Bearer tenant-42",
+			},
+			{
+				name: "basic",
+				auth: config.Auth{Type: config.AuthTypeBasic, User: "{{.user}}", Pass: "secret"},
+				want: "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")),
+			},
+			{
+				name:   "basic from incoming headers",
+				auth:   config.Auth{Type: config.AuthTypeBasic, User: `{{._headers.Get "X-User"}}`, Pass: `{{._headers.Get "X-Pass"}}`},
+				header: http.Header{"X-User": {"bob"}, "X-Pass": {"hunter2"}},
+				want:   "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:hunter2")),
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, Auth: &tt.auth})
+				require.NoError(t, err)
+
+				req := webhookRequest(http.MethodPost, token, `{"value":"hello","key":"from-payload","user":"alice"}`)
+				for k, v := range tt.header {
+					req.Header[k] = v
+				}
+
+				rec := httptest.NewRecorder()
+				s.handleWebhook(rec, req)
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, tt.want, capturedAuth)
+			})
+		}
+	})
+
+	t.Run("partials are invoked from the main template", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{
+			URL:  remote.URL,
+			Tmpl: `{"author": {{template "user" .author}}, "assignees": [{{range $i, $u := .assignees}}{{if $i}}, {{end}}{{template "user" $u}}{{end}}]}`,
+			Partials: map[string]string{
+				"user":    `{"login": "{{.login}}", "url": "{{template "profile" .login}}"}`,
+				"profile": `https://github.com/{{.}}`,
+			},
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token,
+			`{"author":{"login":"alice"},"assignees":[{"login":"bob"},{"login":"carol"}]}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"author": {"login": "alice", "url": "https://github.com/alice"},
+			"assignees": [
+				{"login": "bob", "url": "https://github.com/bob"},
+				{"login": "carol", "url": "https://github.com/carol"}
+			]
+		}`, capturedBody)
+	})
+
+	t.Run("templated query parameters are added to the outbound URL", func(t *testing.T) {
+		var capturedQuery neturl.Values
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			capturedQuery = r.URL.Query()
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL + "/hook?source=github&id=static", Tmpl: "{{.value}}",
+			Query: map[string]string{"id": "{{.id}}", "title": "{{.title}}"}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello","id":42,"title":"a&b=c d"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, neturl.Values{"source": {"github"}, "id": {"42"}, "title": {"a&b=c d"}}, capturedQuery)
+	})
+
+	t.Run("templated headers are set on the outbound request", func(t *testing.T) {
+		var captured http.Header
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			captured = r.Header.Clone()
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}",
+			Headers: map[string]string{"X-Source": "github", "X-Event-ID": "{{.id}}"}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello","id":42}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "github", captured.Get("X-Source"))
+		assert.Equal(t, "42", captured.Get("X-Event-ID"))
+	})
+
+	t.Run("request nonce is the same in headers and body", func(t *testing.T) {
+		var header http.Header
+		var body []byte
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Clone()
+			body, _ = io.ReadAll(r.Body)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"nonce":"{{requestNonce}}","value":"{{.value}}"}`,
+			Headers: map[string]string{"X-Nonce": "{{requestNonce}}", "X-Signature": `{{hmacSHA256 "key" requestNonce}}`}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var got struct{ Nonce string }
+		require.NoError(t, json.Unmarshal(body, &got))
+		assert.Len(t, got.Nonce, 32)
+		assert.Equal(t, got.Nonce, header.Get("X-Nonce"))
+		assert.Equal(t, hmacSHA256("key", got.Nonce), header.Get("X-Signature"))
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, got.Nonce, header.Get("X-Nonce"), "nonce must differ between requests")
+	})
+
+	t.Run("request ID is passed to the remote", func(t *testing.T) {
+		var captured http.Header
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			captured = r.Header.Clone()
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+
+		send := func(t *testing.T, reqID string) {
+			t.Helper()
+			captured = nil
+			req := webhookRequest(http.MethodPost, token, `{"value":"hello"}`)
+			if reqID != "" {
+				req.Header.Set("X-Request-ID", reqID)
+			}
+			rec := httptest.NewRecorder()
+			AssignRequestID(http.HandlerFunc(s.handleWebhook)).ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+
+		t.Run("incoming", func(t *testing.T) {
+			send(t, "req-42")
+			assert.Equal(t, "req-42", captured.Get("X-Request-ID"))
+		})
+
+		t.Run("generated", func(t *testing.T) {
+			send(t, "")
+			assert.Len(t, captured.Get("X-Request-ID"), 36)
+		})
+
+		t.Run("custom header", func(t *testing.T) {
+			s.RequestIDHeader = "X-Correlation-ID"
+			send(t, "req-42")
+			assert.Equal(t, "req-42", captured.Get("X-Correlation-ID"))
+			assert.Empty(t, captured.Get("X-Request-ID"))
+		})
+
+		t.Run("disabled", func(t *testing.T) {
+			s.RequestIDHeader = "-"
+			send(t, "req-42")
+			assert.Empty(t, captured.Get("X-Request-ID"))
+		})
+	})
+
+	t.Run("payload failing the condition is not forwarded", func(t *testing.T) {
+		var calls int
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}", Condition: `{{ne .type "ping"}}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"type":"ping","value":"x"}`))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, rec.Body.String())
+		assert.Zero(t, calls, "ping must not be forwarded")
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"type":"push","value":"x"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("failed condition returns 422", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com", Tmpl: "{{.value}}", Condition: `{{index .list 5}}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"list":[]}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "failed to render condition")
+	})
+
+	t.Run("large outbound body is compressed", func(t *testing.T) {
+		var encoding, body string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding = r.Header.Get("Content-Encoding")
+			rd, err := decompress(r.Body, encoding)
+			require.NoError(t, err)
+			b, err := io.ReadAll(rd)
+			require.NoError(t, err)
+			body = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v": "{{.value}}"}`, CompressOutbound: true})
+		require.NoError(t, err)
+
+		large := strings.Repeat("a", minCompressSize)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"`+large+`"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "gzip", encoding)
+		assert.JSONEq(t, `{"v": "`+large+`"}`, body)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"small"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, encoding, "small bodies are sent as is")
+		assert.JSONEq(t, `{"v": "small"}`, body)
+	})
+
+	t.Run("raw request template describes the whole outbound request", func(t *testing.T) {
+		var captured *http.Request
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			captured = r
+			b, _ := io.ReadAll(r.Body)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{RawRequest: true, Tmpl: `
+PUT ` + remote.URL + `/items/{{.id}}
+X-Source: github
+Content-Type: text/plain
+
+{{.value}}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello","id":42}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, captured)
+		assert.Equal(t, http.MethodPut, captured.Method)
+		assert.Equal(t, "/items/42", captured.URL.Path)
+		assert.Equal(t, "github", captured.Header.Get("X-Source"))
+		assert.Equal(t, "text/plain", captured.Header.Get("Content-Type"))
+		assert.Equal(t, "hello", capturedBody)
+	})
+
+	t.Run("raw request with disallowed URL returns 422", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{RawRequest: true, Tmpl: "POST file:///etc/passwd\n\n{}"})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), `invalid raw request: URL scheme \"file\" is not allowed`)
+	})
+
+	t.Run("unsupported remote scheme returns 400", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		for _, remote := range []string{"ws://example.com/socket", "wss://example.com/socket", "ftp://example.com/file"} {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote, Tmpl: "{{.value}}"})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, remote)
+			assert.Contains(t, rec.Body.String(), "unsupported scheme", remote)
+		}
+	})
+
+	t.Run("webhook without URL responds with the rendered output", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{Tmpl: `{"echo": "{{.value}}"}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"echo": "hello"}`, rec.Body.String())
+
+		token, err = s.Sealer.Seal(config.Webhook{Tmpl: `<ok>{{.value}}</ok>`, ContentType: "application/xml", ForceStatus: http.StatusAccepted})
+		require.NoError(t, err)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "<ok>hello</ok>", rec.Body.String())
+	})
+
+	t.Run("user agent", func(t *testing.T) {
+		var got string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.UserAgent()
+		}))
+		defer remote.Close()
+
+		tests := []struct {
+			name      string
+			serverUA  string
+			cfg       config.Webhook
+			wantAgent string
+		}{
+			{name: "default", wantAgent: "remapjson/test"},
+			{name: "server", serverUA: "acme-relay/1.0", wantAgent: "acme-relay/1.0"},
+			{name: "per webhook", serverUA: "acme-relay/1.0", cfg: config.Webhook{UserAgent: "hook/2.0"}, wantAgent: "hook/2.0"},
+			{
+				name: "header wins", serverUA: "acme-relay/1.0",
+				cfg:       config.Webhook{UserAgent: "hook/2.0", Headers: map[string]string{"User-Agent": "explicit/3.0"}},
+				wantAgent: "explicit/3.0",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+					Client: remote.Client(), UserAgent: tt.serverUA}
+
+				tt.cfg.URL, tt.cfg.Tmpl = remote.URL, "{{.value}}"
+				token, err := s.Sealer.Seal(tt.cfg)
+				require.NoError(t, err)
+
+				rec := httptest.NewRecorder()
+				s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+				require.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, tt.wantAgent, got)
+			})
+		}
+	})
+
+	t.Run("outbound body is signed with templated secret", func(t *testing.T) {
+		var captured http.Header
+		var capturedBody []byte
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			captured = r.Header.Clone()
+			capturedBody, _ = io.ReadAll(r.Body)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"text":"{{.value}}"}`,
+			OutboundSigning: &config.OutboundSigning{Secret: "key-{{.tenant}}"}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello","tenant":"acme"}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		mac := hmac.New(sha256.New, []byte("key-acme"))
+		_, _ = mac.Write([]byte(`{"text":"hello"}`))
+		assert.Equal(t, `{"text":"hello"}`, string(capturedBody))
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), captured.Get("X-Signature"))
+	})
+
+	t.Run("raw body is wrapped into envelope", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL,
+			Tmpl: `{"type": "{{.type}}", "event": {{rawJSON ._raw}}, "text": "{{jsonEscape ._raw}}"}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"type": "push", "n": 1}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"type": "push", "event": {"type": "push", "n": 1}, "text": "{\"type\": \"push\", \"n\": 1}"}`, capturedBody)
+	})
+
+	t.Run("multipart form is sent", func(t *testing.T) {
+		var fields map[string][]string
+		var file, filename string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !assert.NoError(t, r.ParseMultipartForm(1<<20)) {
+				return
+			}
+			fields = r.MultipartForm.Value
+			f, fh, err := r.FormFile("file")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer f.Close()
+			b, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			file, filename = string(b), fh.Filename
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Multipart: true,
+			Tmpl: `[{"name": "channels", "value": "{{.channel}}"},
+				{"name": "file", "filename": "{{.name}}", "content_type": "text/plain", "value": "{{.content}}", "base64": true}]`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"channel": "C123", "name": "hi.txt", "content": "aGVsbG8="}`))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, map[string][]string{"channels": {"C123"}}, fields)
+		assert.Equal(t, "hello", file)
+		assert.Equal(t, "hi.txt", filename)
+
+		t.Run("invalid parts", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Multipart: true, Tmpl: `[{"value": "{{.channel}}"}]`})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"channel": "C123"}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid multipart form: part 0: missing name")
+		})
+	})
+
+	t.Run("url-encoded form is sent", func(t *testing.T) {
+		var contentType string
+		var form neturl.Values
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			if assert.NoError(t, r.ParseForm()) {
+				form = r.PostForm
+			}
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, URLEncoded: true, ContentType: "application/json",
+			Tmpl: `{"channel": "{{.channel}}", "text": "{{jsonEscape .text}}", "tags": ["ci", "deploy"], "retries": 3, "skip": null}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"channel": "ops", "text": "build \"42\" passed & deployed"}`))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/x-www-form-urlencoded", contentType)
+		assert.Equal(t, neturl.Values{
+			"channel": {"ops"},
+			"text":    {`build "42" passed & deployed`},
+			"tags":    {"ci", "deploy"},
+			"retries": {"3"},
+		}, form)
+
+		t.Run("nested object", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, URLEncoded: true, Tmpl: `{"user": {"name": "{{.channel}}"}}`})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"channel": "ops"}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), `invalid url-encoded form: field \"user\": nested objects and arrays can't be form-encoded`)
+		})
+	})
+
+	t.Run("double pass renders the rendered template", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), MaxBodySize: 64}
+
+		tmpl := `{"value": "{{"{{"}}.{{.field}}{{"}}"}}"}`
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: tmpl, DoublePass: true})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"field": "name", "name": "octocat"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"value": "octocat"}`, capturedBody)
+
+		t.Run("invalid first pass output", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"field": "name}}{{"}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid rendered template")
+		})
+
+		t.Run("output growing over the limit", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, DoublePass: true,
+				Tmpl: `{{"{{"}}range .items}}{{"{{"}}.}}{{"{{"}}.}}{{"{{"}}.}}{{"{{"}}.}}{{"{{"}}end}}`})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"items": ["aaaaaaaaaaaa", "bbbbbbbbbbbb"]}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "second pass: template output is too large, over 64 bytes")
+		})
+
+		t.Run("payload code looping over the limit is aborted", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"field": "name}}{{range 1000000000}}xxxx{{end}}{{.name"}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "second pass: template output is too large, over 64 bytes")
+		})
+
+		t.Run("first pass output over the limit", func(t *testing.T) {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, DoublePass: true, Tmpl: `{{range 100}}x{{end}}`})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "template output is too large, over 64 bytes")
+		})
+
+		t.Run("payload code can't read environment and nonce", func(t *testing.T) {
+			t.Setenv("REMAPJSON_TEST_SECRET", "s3cr3t")
+			s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+				Client: remote.Client(), TemplateEnv: []string{"REMAPJSON_TEST_SECRET"}}
+
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, DoublePass: true, Tmpl: `{{.code}} {{env "REMAPJSON_TEST_SECRET"}}`})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"code": "ok"}`))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "ok s3cr3t", capturedBody, "the configured template may read it")
+
+			for _, code := range []string{`{{env \"REMAPJSON_TEST_SECRET\"}}`, `{{requestNonce}}`} {
+				rec = httptest.NewRecorder()
+				s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"code": "`+code+`"}`))
+				assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, code)
+				assert.Contains(t, rec.Body.String(), "not available in the second pass", code)
+				assert.NotContains(t, rec.Body.String(), "s3cr3t", code)
+			}
+		})
+	})
+
+	t.Run("fallback body is sent when template fails", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		tmpl := `{"name": "{{index .names 0}}"}`
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: tmpl, FallbackBody: `{"name": "unknown"}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"names": ["alice"]}`))
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.JSONEq(t, `{"name": "alice"}`, capturedBody)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"org": "acme"}`))
+		assert.Equal(t, http.StatusAccepted, rec.Code, "remote response is returned")
+		assert.JSONEq(t, `{"name": "unknown"}`, capturedBody)
+
+		t.Run("without fallback", func(t *testing.T) {
+			capturedBody = ""
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: tmpl})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"org": "acme"}`))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Empty(t, capturedBody, "nothing is forwarded")
+		})
+	})
+
+	t.Run("constants are merged with payload", func(t *testing.T) {
+		var capturedBody, capturedHeader string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody, capturedHeader = string(b), r.Header.Get("X-Env")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL,
+			Tmpl:      `{"source": "{{._const.source}}", "tag": "{{index ._const.tags 1}}", "text": "{{.text}}", "n": {{.n}}}`,
+			Constants: json.RawMessage(`{"source": "prod", "tags": ["a", "b"]}`),
+			Headers:   map[string]string{"X-Env": "{{._const.source}}"},
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"text": "hello", "n": 1}`))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.JSONEq(t, `{"source": "prod", "tag": "b", "text": "hello", "n": 1}`, capturedBody)
+		assert.Equal(t, "prod", capturedHeader)
+	})
+
+	t.Run("path segments after token are available to template", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL,
+			Tmpl: `{"kind": "{{index ._path 0}}", "id": "{{index ._path 1}}", "name": "{{.name}}"}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `{"name":"alice"}`)
+		req.SetPathValue("rest", "users/42")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"kind": "users", "id": "42", "name": "alice"}`, capturedBody)
+	})
+
+	t.Run("base64 payload is forwarded as raw bytes", func(t *testing.T) {
+		var captured []byte
+		var contentType string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			captured, _ = io.ReadAll(r.Body)
+			contentType = r.Header.Get("Content-Type")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: "{{.file.data}}",
+			Base64Body: true, ContentType: "application/octet-stream"})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"file":{"data":"AAEC/w=="}}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []byte{0x00, 0x01, 0x02, 0xff}, captured)
+		assert.Equal(t, "application/octet-stream", contentType)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"file":{"data":"not base64!"}}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "rendered body is not valid base64")
+	})
+
+	t.Run("rendered JSON is reformatted", func(t *testing.T) {
+		var captured string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			captured = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		tests := []struct {
+			name string
+			mode config.JSONMode
+			tmpl string
+			want string
+		}{
+			{name: "as is", mode: config.JSONModeAsIs, tmpl: `{ "v":  "{{.value}}" }`, want: `{ "v":  "x" }`},
+			{name: "compact", mode: config.JSONModeCompact, tmpl: "{\n  \"v\": \"{{.value}}\",\n  \"n\": [1, 2]\n}", want: `{"v":"x","n":[1,2]}`},
+			{name: "pretty", mode: config.JSONModePretty, tmpl: `{"v":"{{.value}}","n":[1]}`, want: "{\n  \"v\": \"x\",\n  \"n\": [\n    1\n  ]\n}"},
+			{name: "not JSON", mode: config.JSONModeCompact, tmpl: `<v> {{.value}} </v>`, want: `<v> x </v>`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: tt.tmpl, JSONMode: tt.mode})
+				require.NoError(t, err)
+
+				rec := httptest.NewRecorder()
+				s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"x"}`))
+				require.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, tt.want, captured)
+			})
+		}
+	})
+
+	t.Run("rendered JSON is converted to YAML", func(t *testing.T) {
+		var captured, contentType string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			captured, contentType = string(b), r.Header.Get("Content-Type")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"name": "{{.name}}", "tags": ["a", "b"]}`, YAMLBody: true})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"name":"alice"}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "name: alice\ntags:\n  - a\n  - b\n", captured)
+		assert.Equal(t, "application/yaml", contentType)
+
+		token, err = s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `name: {{.name}}`, YAMLBody: true})
+		require.NoError(t, err)
+
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"name":"alice"}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "can't be converted to YAML")
+	})
+
+	t.Run("remote status is overridden", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, "remote body")
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		for remoteStatus, want := range map[int]int{502: 202, 500: 200, 201: 200} {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL + "?status=" + strconv.Itoa(remoteStatus), Tmpl: "{{.value}}",
+				StatusMap: map[int]int{502: 202}, ForceStatus: 200})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+			assert.Equal(t, want, rec.Code, remoteStatus)
+			assert.Equal(t, "remote body", rec.Body.String())
+		}
+	})
+
+	t.Run("custom delimiters keep literal braces", func(t *testing.T) {
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"text": "Hi {{name}}, [[.value]]"}`,
+			Delims: [2]string{"[[", "]]"}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"text": "Hi {{name}}, hello"}`, capturedBody)
+	})
+
+	t.Run("remote response is logged without sensitive data", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewTextHandler(buf, nil)))
+		t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer remote.Close()
+		u, err := neturl.Parse(remote.URL)
+		require.NoError(t, err)
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v":"{{.value}}"}`})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"s3cr3t"}`))
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var line string
+		for l := range strings.Lines(buf.String()) {
+			if strings.Contains(l, "remote responded") {
+				line = l
+			}
+		}
+		require.NotEmpty(t, line)
+		assert.Contains(t, line, "token="+config.Fingerprint(token))
+		assert.Contains(t, line, "host="+u.Host)
+		assert.Contains(t, line, "status=201")
+		assert.Contains(t, line, "latency=")
+		assert.NotContains(t, line, "s3cr3t")
+		assert.NotContains(t, line, token)
+
+		t.Run("disabled", func(t *testing.T) {
+			buf.Reset()
+			s.NoRemoteLog = true
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"s3cr3t"}`))
+			require.Equal(t, http.StatusCreated, rec.Code)
+			assert.NotContains(t, buf.String(), "remote responded")
+		})
+	})
+
+	t.Run("remote errors are answered with 502", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"message":"remote says no"}`))
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		tests := []struct {
+			name     string
+			fail     bool
+			status   int
+			wantCode int
+			wantBody string
+		}{
+			{name: "error passed through by default", status: http.StatusNotFound, wantCode: http.StatusNotFound,
+				wantBody: `{"message":"remote says no"}`},
+			{name: "client error", fail: true, status: http.StatusNotFound, wantCode: http.StatusBadGateway,
+				wantBody: `{"error":"remote responded with 404 Not Found","code":"remote_failed","details":{"remote_status":404}}`},
+			{name: "server error", fail: true, status: http.StatusServiceUnavailable, wantCode: http.StatusBadGateway,
+				wantBody: `{"error":"remote responded with 503 Service Unavailable","code":"remote_failed","details":{"remote_status":503}}`},
+			{name: "success", fail: true, status: http.StatusAccepted, wantCode: http.StatusAccepted,
+				wantBody: `{"message":"remote says no"}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL + "?status=" + strconv.Itoa(tt.status),
+					Tmpl: "{{.value}}", FailOnRemoteError: tt.fail})
+				require.NoError(t, err)
+
+				rec := httptest.NewRecorder()
+				s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+				assert.Equal(t, tt.wantCode, rec.Code)
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			})
+		}
+	})
+
+	t.Run("remote response headers are passed through", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Header().Set("Location", "/items/1")
+			w.Header().Set("X-RateLimit-Remaining", "41")
+			w.Header().Set("App-Name", "remote")
+			w.Header().Set("Connection", "X-Hop")
+			w.Header().Set("X-Hop", "1")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: remote.Client()}
+
+		send := func(t *testing.T, allow []string) *httptest.ResponseRecorder {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, ResponseHeaders: allow})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			rec.Header().Set("App-Name", "remapjson") // set by the app info middleware
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+			require.Equal(t, http.StatusCreated, rec.Code)
+			return rec
+		}
+
+		t.Run("all", func(t *testing.T) {
+			rec := send(t, nil)
+			assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "/items/1", rec.Header().Get("Location"))
+			assert.Equal(t, "41", rec.Header().Get("X-RateLimit-Remaining"))
+			assert.Equal(t, []string{"remapjson"}, rec.Header().Values("App-Name"), "middleware headers are kept")
+			assert.Empty(t, rec.Header().Get("Connection"), "hop-by-hop headers are dropped")
+			assert.Empty(t, rec.Header().Get("X-Hop"), "headers listed in Connection are dropped")
+		})
+
+		t.Run("allowed only", func(t *testing.T) {
+			rec := send(t, []string{"location", "X-Hop"})
+			assert.Equal(t, "/items/1", rec.Header().Get("Location"))
+			assert.Empty(t, rec.Header().Get("Content-Type"))
+			assert.Empty(t, rec.Header().Get("X-RateLimit-Remaining"))
+			assert.Empty(t, rec.Header().Get("X-Hop"), "hop-by-hop headers can't be allowed")
+		})
+	})
+
+	t.Run("dry run renders the request without sending it", func(t *testing.T) {
+		calls := 0
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), AllowDryRun: true}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{"v":"{{.value}}"}`,
+			Auth: &config.Auth{Type: config.AuthTypeBearer, Token: "secret"}})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPut, token, `{"value":"hello"}`)
+		req.Header.Set("X-RemapJSON-DryRun", "true")
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 0, calls)
+		var desc requestDescription
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&desc))
+		assert.Equal(t, http.MethodPut, desc.Method)
+		assert.Equal(t, remote.URL, desc.URL)
+		assert.Equal(t, `{"v":"hello"}`, desc.Body)
+		assert.Equal(t, "application/json", desc.Headers.Get("Content-Type"))
+		assert.Equal(t, "[REDACTED]", desc.Headers.Get("Authorization"))
+
+		s.AllowDryRun = false
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, 1, calls, "dry run header is ignored when not allowed")
+	})
+
+	t.Run("environment is not available when the output is disclosed", func(t *testing.T) {
+		t.Setenv("REMAPJSON_TEST_SECRET", "s3cr3t")
+		var capturedBody string
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			capturedBody = string(b)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), AllowDryRun: true, TemplateEnv: []string{"REMAPJSON_TEST_SECRET"}}
+
+		remoteToken, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{env "REMAPJSON_TEST_SECRET"}}`})
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, remoteToken, `{}`))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "s3cr3t", capturedBody, "sent to the remote")
+
+		req := webhookRequest(http.MethodPost, remoteToken, `{}`)
+		req.Header.Set("X-RemapJSON-DryRun", "true")
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, "dry run")
+		assert.Contains(t, rec.Body.String(), "environment variables are not available when the output is shown to the caller")
+		assert.NotContains(t, rec.Body.String(), "s3cr3t")
+
+		localToken, err := s.Sealer.Seal(config.Webhook{Tmpl: `{{env "REMAPJSON_TEST_SECRET"}}`})
+		require.NoError(t, err)
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, localToken, `{}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, "local webhook")
+		assert.Contains(t, rec.Body.String(), "environment variables are not available when the output is shown to the caller")
+		assert.NotContains(t, rec.Body.String(), "s3cr3t")
+
+		headerToken, err := s.Sealer.Seal(config.Webhook{URL: remote.URL,
+			Headers: map[string]string{"X-API-Key": `{{env "REMAPJSON_TEST_SECRET"}}`}})
+		require.NoError(t, err)
+		req = webhookRequest(http.MethodPost, headerToken, `{}`)
+		req.Header.Set("X-RemapJSON-DryRun", "true")
+		rec = httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, "header in dry run")
+		assert.NotContains(t, rec.Body.String(), "s3cr3t")
+	})
+
+	t.Run("idempotent retries are replayed without calling the remote", func(t *testing.T) {
+		calls := 0
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("X-Call", strconv.Itoa(calls))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprintf(w, "call %d", calls)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), IdempotencyTTL: time.Minute}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, IdempotencyHeader: "X-Delivery-ID"})
+		require.NoError(t, err)
+
+		send := func(deliveryID string) *httptest.ResponseRecorder {
+			req := webhookRequest(http.MethodPost, token, `{"value":"hello"}`)
+			if deliveryID != "" {
+				req.Header.Set("X-Delivery-ID", deliveryID)
+			}
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, req)
+			return rec
+		}
+
+		rec := send("1")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "call 1", rec.Body.String())
+		assert.Empty(t, rec.Header().Get("X-Idempotent-Replay"))
+
+		rec = send("1")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "call 1", rec.Body.String())
+		assert.Equal(t, "1", rec.Header().Get("X-Call"), "remote headers are replayed")
+		assert.Equal(t, "true", rec.Header().Get("X-Idempotent-Replay"))
+		assert.Equal(t, 1, calls)
+
+		rec = send("2")
+		assert.Equal(t, "call 2", rec.Body.String())
+		assert.Empty(t, rec.Header().Get("X-Idempotent-Replay"))
+
+		rec = send("")
+		assert.Equal(t, "call 3", rec.Body.String(), "requests without the header are not deduplicated")
+		rec = send("")
+		assert.Equal(t, "call 4", rec.Body.String())
+	})
+
+	t.Run("remote server errors are not replayed", func(t *testing.T) {
+		calls := 0
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer remote.Close()
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), IdempotencyTTL: time.Minute}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL, Tmpl: `{{.value}}`, IdempotencyHeader: "X-Delivery-ID"})
+		require.NoError(t, err)
+
+		for range 2 {
+			req := webhookRequest(http.MethodPost, token, `{"value":"hello"}`)
+			req.Header.Set("X-Delivery-ID", "1")
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, req)
+			assert.Equal(t, http.StatusBadGateway, rec.Code)
+			assert.Empty(t, rec.Header().Get("X-Idempotent-Replay"))
+		}
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("per-webhook timeout overrides the client timeout", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+		}))
+		defer remote.Close()
+
+		client := remote.Client()
+		client.Timeout = 50 * time.Millisecond
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: client}
+
+		send := func(cfg config.Webhook) int {
+			token, err := s.Sealer.Seal(cfg)
+			require.NoError(t, err)
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"hello"}`))
+			return rec.Code
+		}
+
+		assert.Equal(t, http.StatusGatewayTimeout, send(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}"}),
+			"client timeout applies by default")
+		assert.Equal(t, http.StatusOK, send(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}", TimeoutSeconds: 1}))
+
+		s.MaxTimeout = 100 * time.Millisecond
+		assert.Equal(t, http.StatusGatewayTimeout, send(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}", TimeoutSeconds: 1}),
+			"timeout is capped by the server maximum")
+
+		client.Timeout = time.Second
+		assert.Equal(t, http.StatusGatewayTimeout, send(config.Webhook{URL: remote.URL, Tmpl: "{{.value}}"}),
+			"client timeout is capped by the server maximum")
+	})
+
+	t.Run("same-host redirect policy", func(t *testing.T) {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("redirect to another host must not be followed")
+		}))
+		defer other.Close()
+
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/moved":
+				http.Redirect(w, r, "/hook", http.StatusTemporaryRedirect)
+			case "/away":
+				http.Redirect(w, r, other.URL, http.StatusTemporaryRedirect)
+			default:
+				w.WriteHeader(http.StatusAccepted)
+			}
+		}))
+		defer remote.Close()
+
+		client := remote.Client()
+		client.CheckRedirect = SameHostRedirects
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: client}
+
+		deliver := func(path string) *httptest.ResponseRecorder {
+			token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL + path, Tmpl: "{{.value}}"})
+			require.NoError(t, err)
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"value":"x"}`))
+			return rec
+		}
+
+		assert.Equal(t, http.StatusAccepted, deliver("/moved").Code)
+
+		rec := deliver("/away")
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Contains(t, rec.Body.String(), "redirect to another host is not allowed")
+	})
+
+	t.Run("refused connection returns 502", func(t *testing.T) {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		remoteURL := remote.URL
+		remote.Close() // close immediately so the connection is refused
+
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"}, Client: &http.Client{}}
+
+		token, err := s.Sealer.Seal(config.Webhook{URL: remoteURL, Tmpl: `{{.value}}`})
+		require.NoError(t, err)
+
+		req := webhookRequest(http.MethodPost, token, `{"value":"hello"}`)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, req)
+
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Contains(t, rec.Body.String(), "remote refused the connection")
+	})
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset by peer") }
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("unexpected EOF") }
+
+func TestServer_timeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  time.Duration
+		max     time.Duration
+		seconds int
+		want    time.Duration
+	}{
+		{name: "no timeouts", want: 0},
+		{name: "client timeout by default", client: 90 * time.Second, want: 90 * time.Second},
+		{name: "webhook timeout", client: 90 * time.Second, seconds: 120, want: 120 * time.Second},
+		{name: "webhook timeout capped", client: 90 * time.Second, max: time.Minute, seconds: 600, want: time.Minute},
+		{name: "client timeout capped", client: 90 * time.Second, max: time.Minute, want: time.Minute},
+		{name: "no client timeout capped", max: time.Minute, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Client: &http.Client{Timeout: tt.client}, MaxTimeout: tt.max}
+			assert.Equal(t, tt.want, s.timeout(config.Webhook{TimeoutSeconds: tt.seconds}))
+		})
+	}
+}
+
+func TestServer_copyResponse(t *testing.T) {
+	t.Run("client failure drains the remote body", func(t *testing.T) {
+		s := &Server{}
+		body := strings.NewReader(strings.Repeat("x", 1024))
+		s.copyResponse(t.Context(), failingWriter{}, body)
+
+		assert.Zero(t, body.Len(), "remote body must be drained")
+		assert.Equal(t, int64(1), s.copyFailures.client.Load())
+		assert.Zero(t, s.copyFailures.remote.Load())
+	})
+
+	t.Run("remote failure is counted separately", func(t *testing.T) {
+		s := &Server{}
+		s.copyResponse(t.Context(), httptest.NewRecorder(), failingReader{})
+
+		assert.Zero(t, s.copyFailures.client.Load())
+		assert.Equal(t, int64(1), s.copyFailures.remote.Load())
+	})
+}
+
+func TestServer_execute(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"wait": func() string { <-release; return "" },
+	}).Parse(`{{.value}}{{if .wait}}{{wait}}{{end}}`))
+
+	t.Run("completes within timeout", func(t *testing.T) {
+		s := &Server{TemplateTimeout: time.Second}
+		out, err := s.execute(tmpl, map[string]any{"value": "hello"}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(out))
+	})
+
+	t.Run("aborts after timeout", func(t *testing.T) {
+		s := &Server{TemplateTimeout: 10 * time.Millisecond}
+		_, err := s.execute(tmpl, map[string]any{"value": "hello", "wait": true}, 0)
+		assert.ErrorIs(t, err, errTemplateTimeout)
+	})
+
+	t.Run("no timeout", func(t *testing.T) {
+		s := &Server{}
+		out, err := s.execute(tmpl, map[string]any{"value": 1}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "1", string(out))
+	})
+
+	t.Run("output limit", func(t *testing.T) {
+		s := &Server{}
+		out, err := s.execute(tmpl, map[string]any{"value": "hello"}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(out))
+
+		_, err = s.execute(tmpl, map[string]any{"value": "hello!"}, 5)
+		assert.ErrorIs(t, err, errOutputTooLarge)
+	})
+
+	t.Run("webhook responds with 422 on timeout", func(t *testing.T) {
+		s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: &http.Client{}, TemplateTimeout: time.Millisecond}
+
+		// a million iterations of the nested ranges
+		token, err := s.Sealer.Seal(config.Webhook{URL: "http://remote.example.com",
+			Tmpl: `{{range .n}}{{range $.n}}{{range $.n}}.{{end}}{{end}}{{end}}`})
+		require.NoError(t, err)
+
+		n, err := json.Marshal(make([]int, 100))
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, token, `{"n":`+string(n)+`}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "template execution timed out")
+	})
+}
+
+func TestServer_forward_maxResponseSize(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 100)
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		_, _ = io.WriteString(w, body)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}))
+	defer remote.Close()
+
+	newServer := func(limit int64) *Server {
+		return &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+			Client: remote.Client(), MaxResponseSize: limit}
+	}
+	seal := func(t *testing.T, s *Server, query string) string {
+		t.Helper()
+		token, err := s.Sealer.Seal(config.Webhook{URL: remote.URL + query, Tmpl: "{{.value}}"})
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("response within limit is streamed", func(t *testing.T) {
+		s := newServer(100)
+		for _, query := range []string{"", "?chunked=1"} {
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, webhookRequest(http.MethodPost, seal(t, s, query), `{"value":"hello"}`))
+			assert.Equal(t, http.StatusOK, rec.Code, query)
+			assert.Len(t, rec.Body.String(), 100, query)
+		}
+	})
+
+	t.Run("declared length over limit returns 502", func(t *testing.T) {
+		s := newServer(99)
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, webhookRequest(http.MethodPost, seal(t, s, ""), `{"value":"hello"}`))
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Contains(t, rec.Body.String(), "remote response is larger than 99 bytes")
+	})
+
+	t.Run("streamed body over limit aborts the connection", func(t *testing.T) {
+		s := newServer(50)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/wh/{token}", s.handleWebhook)
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+
+		resp, err := http.Post(ts.URL+"/wh/"+seal(t, s, "?chunked=1"), "application/json", strings.NewReader(`{"value":"hello"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Less(t, len(b), 100)
+	})
+}
+
+func TestParseRawRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    rawRequest
+		wantErr string
+	}{
+		{
+			name: "full request",
+			raw:  "\n  POST https://example.com/hook HTTP/1.1\r\nX-A: 1\r\nx-b: 2\r\n\r\n{\"a\":1}\n",
+			want: rawRequest{method: "POST", url: "https://example.com/hook",
+				header: http.Header{"X-A": {"1"}, "X-B": {"2"}}, body: []byte("{\"a\":1}\n")},
+		},
+		{
+			name: "no headers and body",
+			raw:  "GET https://example.com/hook",
+			want: rawRequest{method: "GET", url: "https://example.com/hook", header: http.Header{}, body: []byte{}},
+		},
+		{name: "empty", raw: "\n\n", wantErr: "missing request line"},
+		{name: "missing URL", raw: "POST\n\n{}", wantErr: `malformed request line "POST", expected "METHOD URL"`},
+		{name: "lowercase method", raw: "post https://example.com\n\n{}", wantErr: `invalid method "post"`},
+		{name: "malformed header", raw: "POST https://example.com\nX-A\n\n{}", wantErr: `malformed headers: malformed MIME header: missing colon: "X-A"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRawRequest([]byte(tt.raw))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTruthy(t *testing.T) {
+	for _, s := range []string{"true", "1", "yes", " ok\n", "push"} {
+		assert.True(t, truthy(s), s)
+	}
+	for _, s := range []string{"", "  ", "false", "FALSE", "0", "no", "off", "<no value>"} {
+		assert.False(t, truthy(s), s)
+	}
+}
+
+func TestCappedReader(t *testing.T) {
+	r := &cappedReader{r: strings.NewReader("hello"), left: 5}
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	r = &cappedReader{r: strings.NewReader("hello, world"), left: 5}
+	b, err = io.ReadAll(r)
+	require.ErrorIs(t, err, errResponseTooLarge)
+	assert.Equal(t, "hello", string(b))
+}
+
+func BenchmarkServer_template(b *testing.B) {
+	s := &Server{TemplateCacheSize: 1000}
+	cfgs := make([]config.Webhook, 100)
+	for i := range cfgs {
+		cfgs[i] = config.Webhook{URL: "https://example.com", Tmpl: fmt.Sprintf(`{"id": {{.id}}, "n": %d, "name": "{{.name}}"}`, i),
+			Partials: map[string]string{"user": `{"login": "{{.login}}"}`}}
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := s.template(cfgs[i%len(cfgs)]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkServer_handleWebhook(b *testing.B) {
+	s := &Server{BaseURL: "http://localhost:8080", Version: "test", Sealer: config.Sealer{Secret: "test-secret"},
+		TemplateCacheSize: 1000, AllowDryRun: true}
+
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	tokens := make([]string, 100)
+	for i := range tokens {
+		var err error
+		tokens[i], err = s.Sealer.Seal(config.Webhook{
+			URL:     "https://example.com",
+			Tmpl:    fmt.Sprintf(`{"id": {{.id}}, "n": %d}`, i),
+			Headers: map[string]string{"X-Source": "bench", "X-ID": "{{.id}}"},
+			Auth:    &config.Auth{Type: config.AuthTypeBearer, Token: "static-token"},
+		})
+		require.NoError(b, err)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			req := webhookRequest(http.MethodPost, tokens[i%len(tokens)], `{"id": 42}`)
+			req.Header.Set("X-RemapJSON-DryRun", "true")
+			rec := httptest.NewRecorder()
+			s.handleWebhook(rec, req)
+			if rec.Code != http.StatusOK {
+				b.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+			}
+			i++
+		}
+	})
+}
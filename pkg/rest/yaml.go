@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jsonToYAML converts the JSON document to YAML, keeping the order of
+// the object keys. Strings, which would be read back as other types,
+// e.g. "true" or "123", stay quoted.
+func jsonToYAML(b []byte) ([]byte, error) {
+	// YAML is a superset of JSON, except for tabs, which compaction removes
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, b); err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+	return encodeYAML(&doc)
+}
+
+// encodeYAML encodes the value as YAML, indented with two spaces.
+func encodeYAML(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle drops the flow and quoting styles of the parsed JSON nodes,
+// leaving it to the encoder to quote the strings where necessary.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, child := range n.Content {
+		blockStyle(child)
+	}
+}
+
+// decodeYAML decodes the YAML document into the same values as
+// the equivalent JSON would be decoded to. If maxDepth is positive,
+// the documents with sequences and mappings nested deeper are rejected
+// on the parsed nodes, before they are converted.
+func decodeYAML(b []byte, maxDepth int) (any, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if maxDepth > 0 && nodeDepth(&doc, map[*yaml.Node]int{}) > maxDepth {
+		return nil, fmt.Errorf("nesting depth exceeds %d", maxDepth)
+	}
+
+	var data any
+	if doc.Kind != 0 { // empty document
+		if err := doc.Decode(&data); err != nil {
+			return nil, err
+		}
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	// re-encoded to get float64 numbers and to reject values like .inf
+	j, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("not representable as JSON: %w", err)
+	}
+	return decodeJSON(j)
+}
+
+// nodeDepth returns how deep the sequences and mappings of the parsed YAML
+// are nested, following the aliases. The depths are memoized by the node,
+// so that the aliases repeating the same anchor are not walked again.
+func nodeDepth(n *yaml.Node, memo map[*yaml.Node]int) int {
+	if d, ok := memo[n]; ok {
+		return d
+	}
+
+	var d int
+	switch n.Kind {
+	case yaml.AliasNode:
+		d = nodeDepth(n.Alias, memo)
+	case yaml.SequenceNode, yaml.MappingNode:
+		for _, child := range n.Content {
+			d = max(d, nodeDepth(child, memo))
+		}
+		d++
+	default: // document and scalars
+		for _, child := range n.Content {
+			d = max(d, nodeDepth(child, memo))
+		}
+	}
+	memo[n] = d
+	return d
+}
+
+// toYAML encodes the value as YAML.
+func toYAML(v any) (string, error) {
+	b, err := encodeYAML(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// fromYAML decodes the YAML document, e.g. a string field of the payload,
+// nested no deeper than the incoming payloads may be.
+func (s *Server) fromYAML(v any) (any, error) {
+	return decodeYAML([]byte(str(v)), s.maxJSONDepth())
+}
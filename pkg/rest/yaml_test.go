@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONToYAML(t *testing.T) {
+	const doc = `{
+	"name": "alice",
+	"age": 42,
+	"ratio": 0.5,
+	"active": true,
+	"nothing": null,
+	"quoted": ["true", "123", "null", "", "a: b"],
+	"nested": {"z": 1, "a": [{"id": 1}, {"id": 2}], "empty": {}, "none": []}
+}`
+
+	out, err := jsonToYAML([]byte(doc))
+	require.NoError(t, err)
+	assert.Equal(t, `name: alice
+age: 42
+ratio: 0.5
+active: true
+nothing: null
+quoted:
+  - "true"
+  - "123"
+  - "null"
+  - ""
+  - 'a: b'
+nested:
+  z: 1
+  a:
+    - id: 1
+    - id: 2
+  empty: {}
+  none: []
+`, string(out))
+
+	t.Run("round trip", func(t *testing.T) {
+		want, err := decodeJSON([]byte(doc))
+		require.NoError(t, err)
+		got, err := decodeYAML(out, 0)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("not JSON", func(t *testing.T) {
+		_, err := jsonToYAML([]byte(`name: alice`))
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeYAML(t *testing.T) {
+	got, err := decodeYAML([]byte("a: 1\nb: [x, 2.5]\n"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"a": 1.0, "b": []any{"x", 2.5}}, got)
+
+	got, err = decodeYAML(nil, 0)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = decodeYAML([]byte("a: .inf\n"), 0)
+	assert.ErrorContains(t, err, "not representable as JSON")
+
+	_, err = decodeYAML([]byte("a: [\n"), 0)
+	assert.Error(t, err)
+
+	t.Run("nesting depth", func(t *testing.T) {
+		_, err := decodeYAML([]byte("a: {b: [1]}\n"), 3)
+		require.NoError(t, err)
+
+		_, err = decodeYAML([]byte("a: {b: [1]}\n"), 2)
+		assert.EqualError(t, err, "nesting depth exceeds 2")
+
+		// aliases add the depth of their anchors, which are walked once
+		laughs := "a: &a [x, x, x, x, x, x, x, x, x]\n"
+		for i, prev := 1, "a"; i < 30; i, prev = i+1, fmt.Sprintf("a%d", i) {
+			laughs += fmt.Sprintf("a%d: &a%d [*%s, *%s, *%s, *%s, *%s, *%s, *%s, *%s, *%s]\n", i, i, prev, prev, prev, prev, prev, prev, prev, prev, prev)
+		}
+		_, err = decodeYAML([]byte(laughs), 16)
+		assert.EqualError(t, err, "nesting depth exceeds 16")
+	})
+}
@@ -0,0 +1,136 @@
+// Package schema implements validation of decoded JSON values against
+// JSON Schema, backed by github.com/santhosh-tekuri/jsonschema.
+//
+// Drafts 4, 6, 7, 2019-09 and 2020-12 are supported, schemas without
+// $schema are compiled as 2020-12. As required by the specification,
+// format is an annotation and unknown keywords are ignored. References
+// are resolved only within the schema itself, e.g. "#/$defs/id", external
+// resources are never loaded, so a schema can't read the server files or
+// call other hosts.
+package schema
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// resource is the URL the compiled schema is registered under, it only
+// appears in the errors of the references to its missing parts.
+const resource = "urn:remapjson:schema"
+
+// Schema is a compiled JSON schema.
+type Schema struct {
+	sch *jsonschema.Schema
+}
+
+// Violation describes a single mismatch of the value against the schema.
+type Violation struct {
+	// Path is the JSON pointer to the offending value, "" for the root.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when the value does not match the schema.
+type ValidationError struct {
+	Violations []Violation
+}
+
+// Error returns the violations joined into a single line.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", displayPath(v.Path), v.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Compile parses and compiles the JSON schema.
+func Compile(raw []byte) (*Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decode schema: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.UseLoader(noLoader{})
+	if err = c.AddResource(resource, doc); err != nil {
+		return nil, fmt.Errorf("add schema: %w", err)
+	}
+
+	sch, err := c.Compile(resource)
+	if serr, ok := errors.AsType[*jsonschema.SchemaValidationError](err); ok {
+		if verr, ok := errors.AsType[*jsonschema.ValidationError](serr.Err); ok {
+			// paths are locations in the schema, as it's validated against the metaschema
+			return nil, fmt.Errorf("invalid schema: %w", &ValidationError{Violations: violations(verr)})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compile schema: %w", err)
+	}
+	return &Schema{sch: sch}, nil
+}
+
+// Validate checks the decoded JSON value against the schema and returns
+// *ValidationError listing all violations, if it doesn't match.
+func (s *Schema) Validate(v any) error {
+	err := s.sch.Validate(v)
+	if err == nil {
+		return nil
+	}
+
+	verr, ok := errors.AsType[*jsonschema.ValidationError](err)
+	if !ok {
+		return fmt.Errorf("validate: %w", err)
+	}
+
+	return &ValidationError{Violations: violations(verr)}
+}
+
+// violations returns the leaf errors of the validation, the ones which
+// are not just failures of their nested schemas, ordered by the path.
+func violations(verr *jsonschema.ValidationError) []Violation {
+	var res []Violation
+	var walk func(unit jsonschema.OutputUnit)
+	walk = func(unit jsonschema.OutputUnit) {
+		for _, nested := range unit.Errors {
+			walk(nested)
+		}
+		if len(unit.Errors) > 0 || unit.Error == nil {
+			return
+		}
+		v := Violation{Path: unit.InstanceLocation, Message: unit.Error.String()}
+		if !slices.Contains(res, v) {
+			res = append(res, v)
+		}
+	}
+	walk(*verr.DetailedOutput())
+
+	if len(res) == 0 { // never happens, but keep the mismatch visible
+		res = append(res, Violation{Message: verr.Error()})
+	}
+	slices.SortStableFunc(res, func(a, b Violation) int { return cmp.Compare(a.Path, b.Path) })
+	return res
+}
+
+// noLoader refuses to load the external resources referenced by the schema.
+type noLoader struct{}
+
+// Load always returns an error.
+func (noLoader) Load(url string) (any, error) {
+	return nil, fmt.Errorf("external references are not allowed, got %q", url)
+}
+
+// displayPath returns the JSON pointer for display, "/" for the root.
+func displayPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
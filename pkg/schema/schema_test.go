@@ -0,0 +1,164 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "empty object", schema: `{}`},
+		{name: "boolean", schema: `true`},
+		{name: "annotations", schema: `{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"t","format":"email"}`},
+		{name: "older draft", schema: `{"$schema":"http://json-schema.org/draft-07/schema#","definitions":{"id":{"type":"string"}}}`},
+		{name: "unknown keyword is ignored", schema: `{"x-custom":{"a":1}}`},
+		{name: "internal reference", schema: `{"$defs":{"id":{"type":"string"}},"properties":{"id":{"$ref":"#/$defs/id"}}}`},
+		{name: "not a JSON", schema: `{`, wantErr: "decode schema: unexpected EOF"},
+		{name: "trailing data", schema: `{} {}`, wantErr: "decode schema: invalid character after top-level value"},
+		{name: "not an object", schema: `"string"`, wantErr: "invalid schema: /: got string, want boolean or object"},
+		{name: "unknown type", schema: `{"type":"float"}`, wantErr: "invalid schema: /type: value must be one of " +
+			"'array', 'boolean', 'integer', 'null', 'number', 'object', 'string'; /type: got string, want array"},
+		{name: "missing reference", schema: `{"$ref":"#/defs/x"}`, wantErr: `compile schema: json-pointer in "urn:remapjson:schema#/defs/x" not found`},
+		{name: "external file reference", schema: `{"$ref":"file:///etc/passwd"}`, wantErr: `compile schema: failing loading "file:///etc/passwd": ` +
+			`external references are not allowed, got "file:///etc/passwd"`},
+		{name: "external URL reference", schema: `{"$ref":"https://example.com/s.json"}`, wantErr: `compile schema: failing loading "https://example.com/s.json": ` +
+			`external references are not allowed, got "https://example.com/s.json"`},
+		{
+			name:    "nested invalid schema",
+			schema:  `{"properties":{"a/b":{"minLength":-1}}}`,
+			wantErr: "invalid schema: /properties/a~1b/minLength: minimum: got -1, want 0",
+		},
+		{name: "invalid pattern", schema: `{"pattern":"("}`, wantErr: "invalid schema: /pattern: '(' is not valid regex: error parsing regexp: missing closing ): `(`"},
+		{name: "zero multipleOf", schema: `{"multipleOf":0}`, wantErr: "invalid schema: /multipleOf: exclusiveMinimum: got 0, want 0"},
+		{name: "empty anyOf", schema: `{"anyOf":[]}`, wantErr: "invalid schema: /anyOf: minItems: got 0, want 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []Violation
+	}{
+		{name: "any value", schema: `{}`, value: `[1, "a"]`},
+		{name: "false schema", schema: `false`, value: `1`, want: []Violation{{Path: "", Message: "false schema"}}},
+		{name: "type", schema: `{"type":"object"}`, value: `[]`, want: []Violation{{Message: "got array, want object"}}},
+		{name: "multiple types", schema: `{"type":["string","null"]}`, value: `null`},
+		{name: "integer", schema: `{"type":"integer"}`, value: `1.0`},
+		{name: "not an integer", schema: `{"type":"integer"}`, value: `1.5`, want: []Violation{{Message: "got number, want integer"}}},
+		{name: "enum", schema: `{"enum":["a",1]}`, value: `1`},
+		{name: "not in enum", schema: `{"enum":["a",1]}`, value: `"b"`, want: []Violation{{Message: "value must be one of 'a', 1"}}},
+		{name: "const", schema: `{"const":{"a":[1]}}`, value: `{"a":[1.0]}`},
+		{name: "format is an annotation", schema: `{"format":"email"}`, value: `"not an email"`},
+		{
+			name:   "object",
+			schema: `{"required":["id","name"],"properties":{"id":{"type":"string"}},"additionalProperties":false}`,
+			value:  `{"id":1,"extra":true}`,
+			want: []Violation{
+				{Path: "", Message: "missing property 'name'"},
+				{Path: "", Message: "additional properties 'extra' not allowed"},
+				{Path: "/id", Message: "got number, want string"},
+			},
+		},
+		{
+			name:   "array",
+			schema: `{"minItems":3,"uniqueItems":true,"items":{"minimum":0}}`,
+			value:  `[1,-1,1]`,
+			want: []Violation{
+				{Path: "", Message: "items at 0 and 2 are equal"},
+				{Path: "/1", Message: "minimum: got -1, want 0"},
+			},
+		},
+		{
+			name:   "string",
+			schema: `{"minLength":2,"maxLength":3,"pattern":"^[a-z]+$"}`,
+			value:  `"ab1c"`,
+			want: []Violation{
+				{Message: "maxLength: got 4, want 3"},
+				{Message: "'ab1c' does not match pattern '^[a-z]+$'"},
+			},
+		},
+		{name: "string length in characters", schema: `{"maxLength":2}`, value: `"привет"`, want: []Violation{{Message: "maxLength: got 6, want 2"}}},
+		{
+			name:   "number",
+			schema: `{"exclusiveMinimum":0,"maximum":10,"multipleOf":0.5}`,
+			value:  `-0.3`,
+			want: []Violation{
+				{Message: "exclusiveMinimum: got -0.3, want 0"},
+				{Message: "multipleOf: got -0.3, want 0.5"},
+			},
+		},
+		{name: "anyOf", schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, value: `1`},
+		{
+			name:   "anyOf lists the failures of all schemas",
+			schema: `{"anyOf":[{"type":"string"},{"type":"null"}]}`,
+			value:  `1`,
+			want:   []Violation{{Message: "got number, want string"}, {Message: "got number, want null"}},
+		},
+		{
+			name:   "oneOf",
+			schema: `{"oneOf":[{"type":"number"},{"type":"integer"}]}`,
+			value:  `1`,
+			want:   []Violation{{Message: "'oneOf' failed, subschemas 0, 1 matched"}},
+		},
+		{name: "not", schema: `{"not":{"type":"null"}}`, value: `null`, want: []Violation{{Message: "'not' failed"}}},
+		{
+			name:   "allOf",
+			schema: `{"allOf":[{"required":["a"]},{"required":["b"]}]}`,
+			value:  `{"a":1}`,
+			want:   []Violation{{Message: "missing property 'b'"}},
+		},
+		{
+			name:   "reference",
+			schema: `{"$defs":{"id":{"type":"string"}},"properties":{"id":{"$ref":"#/$defs/id"}}}`,
+			value:  `{"id":1}`,
+			want:   []Violation{{Path: "/id", Message: "got number, want string"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile([]byte(tt.schema))
+			require.NoError(t, err)
+
+			var v any
+			require.NoError(t, json.Unmarshal([]byte(tt.value), &v))
+
+			err = s.Validate(v)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			require.True(t, ok, "expected validation error, got %v", err)
+			assert.Equal(t, tt.want, verr.Violations)
+		})
+	}
+}
+
+func TestValidationError_Error(t *testing.T) {
+	err := &ValidationError{Violations: []Violation{
+		{Path: "", Message: `missing required property "id"`},
+		{Path: "/tags/1", Message: "expected string, but got number"},
+	}}
+	assert.EqualError(t, err, `/: missing required property "id"; /tags/1: expected string, but got number`)
+}
@@ -0,0 +1,121 @@
+// Package store provides persistence of configured webhooks.
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Webhook is a record of a configured webhook.
+// Only the sealed token is stored, never the plaintext configuration.
+type Webhook struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// File keeps webhook records in memory and persists them in a JSON file.
+type File struct {
+	path string
+
+	mu    sync.RWMutex
+	items map[string]Webhook
+}
+
+// NewFile makes a new File store, loading existing records from the path,
+// if the file exists.
+func NewFile(path string) (*File, error) {
+	f := &File{path: path, items: map[string]Webhook{}}
+
+	b, err := os.ReadFile(path) //nolint:gosec // path is provided by the operator
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return f, nil
+	case err != nil:
+		return nil, fmt.Errorf("read store file: %w", err)
+	}
+
+	var items []Webhook
+	if err = json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("unmarshal store file: %w", err)
+	}
+	for _, item := range items {
+		f.items[item.ID] = item
+	}
+
+	return f, nil
+}
+
+// Put adds the webhook record or replaces the existing one with the same ID.
+func (f *File) Put(_ context.Context, wh Webhook) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev, existed := f.items[wh.ID]
+	f.items[wh.ID] = wh
+	if err := f.flush(); err != nil {
+		if existed {
+			f.items[wh.ID] = prev
+		} else {
+			delete(f.items, wh.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// List returns all webhook records, ordered by creation time.
+func (f *File) List(context.Context) ([]Webhook, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.sorted(), nil
+}
+
+func (f *File) sorted() []Webhook {
+	res := make([]Webhook, 0, len(f.items))
+	for _, item := range f.items {
+		res = append(res, item)
+	}
+	slices.SortFunc(res, func(a, b Webhook) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return res
+}
+
+// flush writes all records to a temporary file and atomically replaces
+// the store file with it. Must be called with the lock held.
+func (f *File) flush() error {
+	b, err := json.MarshalIndent(f.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal records: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after successful rename
+
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("replace store file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	f, err := NewFile(path)
+	require.NoError(t, err)
+
+	list, err := f.List(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	require.NoError(t, f.Put(t.Context(), Webhook{ID: "b", Token: "token-b", CreatedAt: now.Add(time.Minute)}))
+	require.NoError(t, f.Put(t.Context(), Webhook{ID: "a", Token: "token-a", CreatedAt: now}))
+	require.NoError(t, f.Put(t.Context(), Webhook{ID: "b", Token: "token-b2", CreatedAt: now.Add(time.Minute)}))
+
+	want := []Webhook{
+		{ID: "a", Token: "token-a", CreatedAt: now},
+		{ID: "b", Token: "token-b2", CreatedAt: now.Add(time.Minute)},
+	}
+
+	list, err = f.List(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, want, list)
+
+	t.Run("records are reloaded from file", func(t *testing.T) {
+		f2, err := NewFile(path)
+		require.NoError(t, err)
+		list, err := f2.List(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, want, list)
+	})
+
+	t.Run("corrupted file fails", func(t *testing.T) {
+		broken := filepath.Join(t.TempDir(), "broken.json")
+		require.NoError(t, os.WriteFile(broken, []byte("not json"), 0o600))
+		_, err := NewFile(broken)
+		assert.Error(t, err)
+	})
+}